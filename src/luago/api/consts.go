@@ -1,19 +1,14 @@
 package api
 
-// LUA_HOOKCALL
-// LUA_HOOKCOUNT
-// LUA_HOOKLINE
-// LUA_HOOKRET
-// LUA_HOOKTAILCALL
-// LUA_MASKCALL
-// LUA_MASKCOUNT
-// LUA_MASKLINE
-// LUA_MASKRET
-// LUA_NOREF
-// LUA_REFNIL
 // LUA_USE_APICHECK
 // LUAL_BUFFERSIZE
 
+/* pre-defined references */
+const (
+	LUA_NOREF  = -2
+	LUA_REFNIL = -1
+)
+
 /* option for multiple returns in 'lua_pcall' and 'lua_call' */
 const LUA_MULTRET = -1
 
@@ -28,6 +23,18 @@ const LUA_MINSTACK = 20
 */
 const LUAI_MAXSTACK = 1000000
 
+/*
+@@ LUAI_MAXCALLS limits the number of nested calls. Every call nests
+** in the Go stack, so it also bounds the growth of the goroutine stack.
+*/
+const LUAI_MAXCALLS = 200000
+
+/*
+@@ LUAI_MAXCCALLS defines a hard limit for the number of nested calls
+** involving Go functions; nested resumes of coroutines count as well.
+*/
+const LUAI_MAXCCALLS = 200
+
 /*
 ** Pseudo-indices
 ** (-LUAI_MAXSTACK is the minimum valid index; we keep some free empty
@@ -110,4 +117,19 @@ const (
 	LUA_ERRGCMM
 	LUA_ERRERR
 	LUA_ERRFILE
+	LUA_ERRQUOTA // a limit set by SetLimits was exceeded
+)
+
+// lua-5.3.4/src/lua.h
+/* garbage-collection options */
+const (
+	LUA_GCSTOP       = 0
+	LUA_GCRESTART    = 1
+	LUA_GCCOLLECT    = 2
+	LUA_GCCOUNT      = 3
+	LUA_GCCOUNTB     = 4
+	LUA_GCSTEP       = 5
+	LUA_GCSETPAUSE   = 6
+	LUA_GCSETSTEPMUL = 7
+	LUA_GCISRUNNING  = 9
 )
@@ -0,0 +1,8 @@
+package api
+
+// reflection-based bridge between Go values and Lua values
+type GoBridge interface {
+	PushGoValue(v interface{})                // push(udata(v)) with a metatable generated from v's type
+	PushGo(v interface{})                     // push(deep copy of v as Lua value)
+	ToGoValue(idx int, out interface{}) error // *out = r[idx] converted to *out's type
+}
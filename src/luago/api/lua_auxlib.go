@@ -8,20 +8,26 @@ type AuxLib interface {
 	Error2(fmt string, a ...interface{}) int // todo
 	ArgError(arg int, extraMsg string) int   // todo
 	Where(lvl int)                           //
+	/* Traceback functions */
+	Traceback(ls1 LuaState, msg string, level int) // push(traceback of ls1)
+	StackFrames(level int) []StackFrame            //
 	/* Argument check functions */
-	CheckStack2(sz int, msg string)               //
-	ArgCheck(cond bool, arg int, extraMsg string) //
-	CheckAny(arg int)                             // r[arg] is None ?
-	CheckType(arg int, t LuaType)                 // r[arg] is LuaType ?
-	CheckInteger(arg int) int64                   // r[arg] is LuaInteger ?
-	CheckNumber(arg int) float64                  // r[arg] is LuaNumber ?
-	CheckString(arg int) string                   // r[arg] is string ?
-	OptInteger(arg int, d int64) int64            // r[arg] or d
-	OptNumber(arg int, d float64) float64         // r[arg] or d
-	OptString(arg int, d string) string           // r[arg] or d
+	CheckStack2(sz int, msg string)                //
+	ArgCheck(cond bool, arg int, extraMsg string)  //
+	CheckAny(arg int)                              // r[arg] is None ?
+	CheckType(arg int, t LuaType)                  // r[arg] is LuaType ?
+	CheckInteger(arg int) int64                    // r[arg] is LuaInteger ?
+	CheckNumber(arg int) float64                   // r[arg] is LuaNumber ?
+	CheckString(arg int) string                    // r[arg] is string ?
+	OptInteger(arg int, d int64) int64             // r[arg] or d
+	OptNumber(arg int, d float64) float64          // r[arg] or d
+	OptString(arg int, d string) string            // r[arg] or d
+	CheckOption(arg int, d string, l []string) int // index of (r[arg] or d) in l
 	/* Load functions */
 	DoFile(filename string) bool                  //
 	DoString(str string) bool                     //
+	DoFileErr(filename string) error              //
+	DoStringErr(str string) error                 //
 	LoadFile(filename string) ThreadStatus        //
 	LoadFileX(filename, mode string) ThreadStatus //
 	LoadString(s string) ThreadStatus             //
@@ -38,18 +44,18 @@ type AuxLib interface {
 	NewLib(l FuncReg)                                    //
 	NewLibTable(l FuncReg)                               //
 	SetFuncs(l FuncReg, nup int)                         // l.each{name,func => r[-1][name]=func}
+	/* Reference system */
+	Ref(t int) int    // r[t][ref] = pop()
+	Unref(t, ref int) // r[t][ref] = nil
+	/* Userdata functions */
+	NewMetatable(tname string) bool            // registry[tname] = {__name=tname}
+	GetMetatable2(tname string) LuaType        // push(registry[tname])
+	SetMetatable2(tname string)                // r[-1].metatable = registry[tname]
+	TestUData(arg int, tname string) UserData  // r[arg].metatable == registry[tname] ? r[arg].data : nil
+	CheckUData(arg int, tname string) UserData // r[arg] is userdata of tname ?
 }
 
 // luaL_fileresult
 // luaL_execresult
-// luaL_checkoption
-// luaL_newmetatable
-// luaL_getmetatable
-// luaL_setmetatable
-// luaL_checkudata
-// luaL_testudata
-// luaL_traceback
 // luaL_gsub
 // luaL_newstate
-// luaL_ref
-// luaL_unref
@@ -1,5 +1,8 @@
 package api
 
+/* maximum size for the description of the source of a function */
+const LUA_IDSIZE = 60
+
 // Event codes
 const (
 	LUA_HOOKCALL = iota
@@ -9,6 +12,14 @@ const (
 	LUA_HOOKTAILCALL
 )
 
+// Event masks
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
+)
+
 // http://www.lua.org/manual/5.3/manual.html#lua_Debug
 type LuaDebug struct {
 	Event           int
@@ -25,7 +36,7 @@ type LuaDebug struct {
 	IsTailCall      bool   /* (t) */
 	ShortSrc        string /* (S) */
 	/* private part */
-	// other fields
+	CallInfo interface{} /* active function */
 }
 
 type LuaHook func(ls LuaState, ar *LuaDebug)
@@ -44,3 +55,44 @@ type DebugAPI interface {
 	UpvalueId(funcIdx, n int) interface{}
 	UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int)
 }
+
+// Returns the printable form of a chunk's source name, as used in
+// error messages and LuaDebug.ShortSrc.
+// lua-5.3.4/src/lobject.c#luaO_chunkid()
+func ChunkID(source string) string {
+	if source == "" {
+		return "?"
+	}
+	switch source[0] {
+	case '=': /* 'literal' source */
+		if len(source) <= LUA_IDSIZE {
+			return source[1:]
+		}
+		return source[1:LUA_IDSIZE]
+	case '@': /* file name */
+		if len(source) <= LUA_IDSIZE {
+			return source[1:]
+		}
+		return "..." + source[len(source)-LUA_IDSIZE+4:]
+	default: /* string; format as [string "source"] */
+		const PRE, POS, RETS = `[string "`, `"]`, "..."
+		l := LUA_IDSIZE - len(PRE+RETS+POS) - 1
+		nl := -1
+		for i := 0; i < len(source); i++ {
+			if source[i] == '\n' {
+				nl = i
+				break
+			}
+		}
+		if len(source) < l && nl < 0 {
+			return PRE + source + POS
+		}
+		if nl >= 0 && nl < l {
+			l = nl
+		}
+		if l > len(source) {
+			l = len(source)
+		}
+		return PRE + source[:l] + RETS + POS
+	}
+}
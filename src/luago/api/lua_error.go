@@ -0,0 +1,41 @@
+package api
+
+import "fmt"
+
+// The error returned by PCallErr and DoStringErr.
+type LuaError struct {
+	Status    ThreadStatus // LUA_ERRRUN, LUA_ERRSYNTAX, LUA_ERRMEM, LUA_ERRERR...
+	Value     interface{}  // the error object
+	Cause     error        // Go error held by the error object (a userdata), if any
+	ChunkName string       // chunk of the innermost running Lua function
+	Line      int          // current line of that function, or -1
+	Traceback string       // Lua stack traceback
+	Frames    []StackFrame // structured form of Traceback
+}
+
+// A frame of a Lua stack traceback, in a form suitable for logging.
+type StackFrame struct {
+	Source      string `json:"source"`                // short source, "[C]" for Go functions
+	Line        int    `json:"line"`                  // current line, or -1
+	Function    string `json:"function"`              // "function 'name'", "main chunk", "?"...
+	LineDefined int    `json:"linedefined,omitempty"` // line where the Lua function starts
+	GoFunction  string `json:"gofunction,omitempty"`  // name of the Go function, if any
+	IsTailCall  bool   `json:"tailcall,omitempty"`    // called by a tail call
+}
+
+func (self *LuaError) Error() string {
+	switch x := self.Value.(type) {
+	case string:
+		return x
+	case int64, float64:
+		return fmt.Sprintf("%v", x)
+	}
+	if self.Cause != nil {
+		return self.Cause.Error()
+	}
+	return "(error object is not a string)"
+}
+
+func (self *LuaError) Unwrap() error {
+	return self.Cause
+}
@@ -0,0 +1,11 @@
+package api
+
+// Resource limits of a state, zero means unlimited. Exceeding a limit
+// raises an error which can't be caught by pcall, the outermost PCall
+// returns LUA_ERRQUOTA.
+type Limits struct {
+	MaxInstructions int64 // number of VM instructions per PCall
+	MaxCallDepth    int   // number of nested calls
+	MaxStackSize    int   // number of slots of each call's stack
+	MaxStringLen    int   // length of strings produced by concatenation
+}
@@ -1,17 +1,22 @@
 package api
 
+import "context"
+
 type LuaType = int
 type ArithOp = int
 type CompareOp = int
 type ThreadStatus = int
 
 type GoFunction func(LuaState) int
+type KContext interface{}
+type KFunction func(ls LuaState, status ThreadStatus, ctx KContext) int
 type UserData interface{}
 
 type LuaState interface {
 	BasicAPI
 	DebugAPI
 	AuxLib
+	GoBridge
 	String() string // debug
 }
 
@@ -47,7 +52,8 @@ type BasicAPI interface {
 	IsThread(idx int) bool             // r[idx].type == LUA_TTHREAD
 	IsFunction(idx int) bool           // r[idx].type == LUA_TFUNCTION
 	IsGoFunction(idx int) bool         // r[idx].type == LUA_TLCL || LUA_TGCL
-	IsUserData(idx int) bool           // r[idx].type == LUA_TUSERDATA
+	IsUserData(idx int) bool           // r[idx].type == LUA_TUSERDATA || LUA_TLIGHTUSERDATA
+	IsLightUserData(idx int) bool      // r[idx].type == LUA_TLIGHTUSERDATA
 	ToBoolean(idx int) bool            // r[idx] as bool
 	ToInteger(idx int) int64           // r[idx] as LuaInteger
 	ToIntegerX(idx int) (int64, bool)  // r[idx] as LuaInteger
@@ -68,7 +74,7 @@ type BasicAPI interface {
 	PushFString(fmt string, a ...interface{}) // push(fmt*a)
 	PushGoFunction(f GoFunction)              // push(f)
 	PushGoClosure(f GoFunction, n int)        // push(f)
-	PushUserData(d UserData)                  // push(d)
+	PushLightUserData(p UserData)             // push(p)
 	PushGlobalTable()                         // push(global)
 	PushThread() bool                         // push(thread)
 	/* Comparison and arithmetic functions */
@@ -77,6 +83,7 @@ type BasicAPI interface {
 	RawEqual(idx1, idx2 int) bool              // r[idx1] == r[idx2]
 	/* get functions (Lua -> stack) */
 	NewTable()                           // push({})
+	NewUserData(v interface{})           // push(udata(v))
 	CreateTable(nArr, nRec int)          // push({})
 	GetTable(idx int) LuaType            // push(r[idx][pop()])
 	GetField(idx int, k string) LuaType  // push(r[idx][k])
@@ -99,12 +106,21 @@ type BasicAPI interface {
 	SetMetatable(idx int)               // r[idx].metatable = pop()
 	SetUserValue(idx int)               // r[idx].userValue = pop()
 	/* 'load' and 'call' functions (load and run Lua code) */
-	Dump(strip bool) []byte                                 // todo
-	Load(chunk []byte, chunkName, mode string) ThreadStatus // push(compile(chunk))
-	Call(nArgs, nResults int)                               // args=pop(nArgs); f=pop(); f(args)
-	CallK()                                                 //
-	PCall(nArgs, nResults, msgh int) ThreadStatus           // call(nArgs, nResults) || push(err)
-	PCallK()                                                //
+	Dump(strip bool) []byte                                                   // todo
+	Load(chunk []byte, chunkName, mode string) ThreadStatus                   // push(compile(chunk))
+	Call(nArgs, nResults int)                                                 // args=pop(nArgs); f=pop(); f(args)
+	CallK(nArgs, nResults int, ctx KContext, k KFunction)                     // call() and k() after yields
+	PCall(nArgs, nResults, msgh int) ThreadStatus                             // call(nArgs, nResults) || push(err)
+	PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) ThreadStatus // pcall() and k() after yields
+	PCallErr(nArgs, nResults, msgh int) error                                 // pcall() and return *LuaError
+	/* context functions (cancellation and deadlines) */
+	SetContext(ctx context.Context)                                           // ctx of calls
+	Context() context.Context                                                 // ctx of calls
+	PCallContext(ctx context.Context, nArgs, nResults, msgh int) ThreadStatus // pcall with ctx
+	/* quota functions (resource limits for sandboxed scripts) */
+	SetLimits(limits Limits)    // limits of calls
+	Limits() Limits             // limits of calls
+	CheckStringLength(size int) // size <= limits.MaxStringLen || quota error
 	/* miscellaneous functions */
 	Concat(n int)                 // push(concat(pop(n)))
 	Len(idx int)                  // push(len(r[idx]))
@@ -112,14 +128,14 @@ type BasicAPI interface {
 	StringToNumber(s string) bool // push(number(s))
 	Error() int                   // panic(r[-1])
 	/* coroutine functions */
-	NewThread() LuaState                          // todo
-	Resume(from LuaState, nArgs int) ThreadStatus // todo
-	Yield(nResults int) int                       // todo
-	YieldK()                                      // todo
-	Status() ThreadStatus                         // todo
-	IsYieldable() bool                            // todo
+	NewThread() LuaState                                // push(newThread())
+	Resume(from LuaState, nArgs int) ThreadStatus       // run until return or yield
+	Yield(nResults int) int                             // suspend with nResults values
+	YieldK(nResults int, ctx KContext, k KFunction) int // yield() and k() when resumed
+	Status() ThreadStatus                               // LUA_OK, LUA_YIELD or error status
+	IsYieldable() bool                                  // can the running thread yield?
 	/* garbage-collection function and options */
-	GC(what, data int) int //
+	GC(what, data int) int // control the collector; what is a LUA_GC* option
 }
 
 // no pseudo-index
@@ -132,16 +148,11 @@ type BasicAPI interface {
 // type LuaNumber float64
 
 // type LuaLightUserData UserData
-// type LuaKContext int
-// type LuaKFunction int
 // type LuaReader int
 // type LuaWriter int
 
 // GetAllocf()
 // GetExtraSpace()
-// IsLightUserData(idx int)
-// NewUserData(size uint)
-// PushLightUserData()
 // PushLiteral
 // PushLString
 // PushVFString()
@@ -3,15 +3,34 @@ package codegen
 import . "luago/compiler/ast"
 
 func cgBlock(fi *funcInfo, node *Block) {
-	for _, stat := range node.Stats {
-		cgStat(fi, stat)
-	}
+	cgStats(fi, node.Stats, node.RetExps == nil)
 
 	if node.RetExps != nil {
 		cgRetStat(fi, node.RetExps, node.LastLine)
 	}
 }
 
+// labels followed only by void statements at the end of
+// block are considered outside the scope of the block's locals
+func cgStats(fi *funcInfo, stats []Stat, voidTail bool) {
+	for i, stat := range stats {
+		if label, ok := stat.(*LabelStat); ok {
+			cgLabelStat(fi, label, voidTail && _onlyLabels(stats[i+1:]))
+		} else {
+			cgStat(fi, stat)
+		}
+	}
+}
+
+func _onlyLabels(stats []Stat) bool {
+	for _, stat := range stats {
+		if _, ok := stat.(*LabelStat); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func cgRetStat(fi *funcInfo, exps []Exp, lastLine int) {
 	nExps := len(exps)
 	if nExps == 0 {
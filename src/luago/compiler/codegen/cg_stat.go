@@ -26,11 +26,21 @@ func cgStat(fi *funcInfo, node Stat) {
 		cgLocalVarDeclStat(fi, stat)
 	case *LocalFuncDefStat:
 		cgLocalFuncDefStat(fi, stat)
-	case *LabelStat, *GotoStat:
-		panic("label and goto statements are not supported!")
+	case *LabelStat:
+		cgLabelStat(fi, stat, false)
+	case *GotoStat:
+		cgGotoStat(fi, stat)
 	}
 }
 
+func cgLabelStat(fi *funcInfo, node *LabelStat, atBlockEnd bool) {
+	fi.addLabel(node.Name, node.Line, atBlockEnd)
+}
+
+func cgGotoStat(fi *funcInfo, node *GotoStat) {
+	fi.addGoto(node.Name, node.Line)
+}
+
 func cgLocalFuncDefStat(fi *funcInfo, node *LocalFuncDefStat) {
 	r := fi.addLocVar(node.Name, fi.pc()+2)
 	cgFuncDefExp(fi, node.Exp, r)
@@ -92,7 +102,12 @@ func cgRepeatStat(fi *funcInfo, node *RepeatStat) {
 	fi.enterScope(true)
 
 	pcBeforeBlock := fi.pc()
-	cgBlock(fi, node.Block)
+	// 'until' can see the local vars of the block,
+	// so the labels before it are not at the end of block
+	cgStats(fi, node.Block.Stats, false)
+	if node.Block.RetExps != nil {
+		cgRetStat(fi, node.Block.RetExps, node.Block.LastLine)
+	}
 
 	oldRegs := fi.usedRegs
 	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
@@ -319,7 +334,7 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 			if a := fi.slotOfLocVar(varName); a >= 0 {
 				fi.emitMove(lastLine, a, vRegs[i])
 			} else if a := fi.indexOfUpval(varName); a >= 0 {
-				fi.emitSetUpval(lastLine, a, vRegs[i])
+				fi.emitSetUpval(lastLine, vRegs[i], a)
 			} else if a := fi.slotOfLocVar("_ENV"); a >= 0 {
 				if kRegs[i] < 0 {
 					b := 0x100 + fi.indexOfConstant(varName)
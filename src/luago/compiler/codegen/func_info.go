@@ -1,5 +1,6 @@
 package codegen
 
+import "fmt"
 import . "luago/compiler/ast"
 import . "luago/compiler/lexer"
 import . "luago/vm"
@@ -35,6 +36,24 @@ type locVarInfo struct {
 	captured bool
 }
 
+type labelInfo struct {
+	name     string
+	line     int
+	pc       int
+	scopeLv  int
+	nActVars int
+}
+
+type gotoInfo struct {
+	name     string
+	line     int
+	jmpPC    int
+	scopeLv  int
+	nActVars int
+	locVars  []*locVarInfo // active local vars at goto
+	label    *labelInfo    // nil if pending
+}
+
 type funcInfo struct {
 	parent    *funcInfo
 	subFuncs  []*funcInfo
@@ -46,6 +65,8 @@ type funcInfo struct {
 	upvalues  map[string]upvalInfo
 	constants map[interface{}]int
 	breaks    [][]int
+	labels    []*labelInfo
+	gotos     []*gotoInfo
 	insts     []uint32
 	lineNums  []uint32
 	line      int
@@ -149,6 +170,8 @@ func (self *funcInfo) exitScope(endPC int) {
 	for _, pc := range pendingBreakJmps {
 		self.fixSbx(pc, self.pc()-pc)
 	}
+
+	self.exitLabelScope()
 }
 
 func (self *funcInfo) removeLocVar(locVar *locVarInfo) {
@@ -178,6 +201,35 @@ func (self *funcInfo) addLocVar(name string, startPC int) int {
 	return newVar.slot
 }
 
+func (self *funcInfo) activeLocVars() []*locVarInfo {
+	locVars := make([]*locVarInfo, 0, self.usedRegs)
+	for _, locVar := range self.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			locVars = append(locVars, v)
+		}
+	}
+	return locVars
+}
+
+func (self *funcInfo) locVarOfSlot(slot int) *locVarInfo {
+	for _, locVar := range self.activeLocVars() {
+		if locVar.slot == slot {
+			return locVar
+		}
+	}
+	return nil
+}
+
+func (self *funcInfo) nActVarsOfOuterScope() int {
+	n := self.usedRegs
+	for _, locVar := range self.activeLocVars() {
+		if locVar.scopeLv == self.scopeLv && locVar.slot < n {
+			n = locVar.slot
+		}
+	}
+	return n
+}
+
 func (self *funcInfo) slotOfLocVar(name string) int {
 	if locVar, found := self.locNames[name]; found {
 		return locVar.slot
@@ -196,6 +248,121 @@ func (self *funcInfo) addBreakJmp(pc int) {
 	panic("<break> at line ? not inside a loop!")
 }
 
+/* labels & gotos */
+
+func (self *funcInfo) addLabel(name string, line int, atBlockEnd bool) {
+	for _, label := range self.labels {
+		if label.name == name && label.scopeLv == self.scopeLv {
+			panic(fmt.Sprintf("label '%s' already defined on line %d",
+				name, label.line))
+		}
+	}
+
+	label := &labelInfo{
+		name:     name,
+		line:     line,
+		pc:       self.pc() + 1,
+		scopeLv:  self.scopeLv,
+		nActVars: self.usedRegs,
+	}
+	if atBlockEnd {
+		// local vars of the block are already out of scope
+		label.nActVars = self.nActVarsOfOuterScope()
+	}
+	self.labels = append(self.labels, label)
+
+	for _, gt := range self.gotos {
+		if gt.label == nil && gt.name == name &&
+			gt.scopeLv == self.scopeLv {
+			self.resolveGoto(gt, label)
+		}
+	}
+}
+
+func (self *funcInfo) addGoto(name string, line int) {
+	gt := &gotoInfo{
+		name:     name,
+		line:     line,
+		jmpPC:    self.emitJmp(line, 0),
+		scopeLv:  self.scopeLv,
+		nActVars: self.usedRegs,
+		locVars:  self.activeLocVars(),
+	}
+	self.gotos = append(self.gotos, gt)
+
+	if label := self.findLabel(name); label != nil { // backward jump
+		self.resolveGoto(gt, label)
+	}
+}
+
+// only labels of the current block are checked, pending gotos
+// will see the labels of enclosing blocks when they move out
+func (self *funcInfo) findLabel(name string) *labelInfo {
+	for i := len(self.labels) - 1; i >= 0; i-- {
+		label := self.labels[i]
+		if label.name == name && label.scopeLv == self.scopeLv {
+			return label
+		}
+	}
+	return nil
+}
+
+func (self *funcInfo) resolveGoto(gt *gotoInfo, label *labelInfo) {
+	if gt.nActVars < label.nActVars {
+		locVar := self.locVarOfSlot(gt.nActVars)
+		panic(fmt.Sprintf("<goto %s> at line %d jumps into the scope of local '%s'",
+			gt.name, gt.line, locVar.name))
+	}
+
+	gt.label = label
+	self.fixSbx(gt.jmpPC, label.pc-gt.jmpPC-1)
+}
+
+func (self *funcInfo) exitLabelScope() {
+	n := 0
+	for _, label := range self.labels {
+		if label.scopeLv <= self.scopeLv {
+			self.labels[n] = label
+			n++
+		}
+	}
+	self.labels = self.labels[:n]
+
+	// move pending gotos out of the block
+	for _, gt := range self.gotos {
+		if gt.label == nil && gt.scopeLv > self.scopeLv {
+			gt.scopeLv = self.scopeLv
+			if gt.nActVars > self.usedRegs {
+				gt.nActVars = self.usedRegs
+			}
+			if label := self.findLabel(gt.name); label != nil {
+				self.resolveGoto(gt, label)
+			}
+		}
+	}
+
+	if self.scopeLv < 0 { // end of function
+		self.closeGotoJmps()
+	}
+}
+
+// all captured local vars are known at the end of function,
+// close their upvalues when gotos jump out of their scope
+func (self *funcInfo) closeGotoJmps() {
+	for _, gt := range self.gotos {
+		if gt.label == nil {
+			panic(fmt.Sprintf("no visible label '%s' for <goto> at line %d",
+				gt.name, gt.line))
+		}
+		for _, locVar := range gt.locVars {
+			if locVar.captured && locVar.slot >= gt.label.nActVars {
+				self.fixJmpA(gt.jmpPC, gt.label.nActVars+1)
+				break
+			}
+		}
+	}
+}
+
 /* upvalues */
 
 func (self *funcInfo) indexOfUpval(name string) int {
@@ -260,6 +427,13 @@ func (self *funcInfo) fixSbx(pc, sBx int) {
 	self.insts[pc] = i
 }
 
+func (self *funcInfo) fixJmpA(pc, a int) {
+	i := self.insts[pc]
+	i = i &^ (0xFF << 6) // clear a
+	i = i | uint32(a)<<6 // reset a
+	self.insts[pc] = i
+}
+
 // todo: rename?
 func (self *funcInfo) fixEndPC(name string, delta int) {
 	for i := len(self.locVars) - 1; i >= 0; i-- {
@@ -7,13 +7,12 @@ import "luago/compiler/parser"
 func Compile(source, chunk string) *binchunk.Prototype {
 	ast := parser.Parse(source, chunk)
 	proto := codegen.GenProto(ast)
-	setSource(proto, source) // todo
+	setSource(proto, source)
 	return proto
 }
 
-// todo
 func setSource(proto *binchunk.Prototype, source string) {
-	proto.Source = "@" + source
+	proto.Source = source
 	for _, f := range proto.Protos {
 		setSource(f, source)
 	}
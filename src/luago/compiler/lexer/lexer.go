@@ -5,6 +5,7 @@ import "fmt"
 import "regexp"
 import "strconv"
 import "strings"
+import "luago/api"
 
 //var reSpaces = regexp.MustCompile(`^\s+`)
 var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
@@ -224,7 +225,7 @@ func (self *Lexer) test(s string) bool {
 
 func (self *Lexer) error(f string, a ...interface{}) {
 	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d: %s", self.source, self.line, err)
+	err = fmt.Sprintf("%s:%d: %s", api.ChunkID(self.source), self.line, err)
 	panic(err)
 }
 
@@ -216,7 +216,7 @@ func TestErrors(t *testing.T) {
 }
 
 func testError(t *testing.T, chunk, expectedErr string) {
-	err := safeNextToken(NewLexer("src", chunk))
+	err := safeNextToken(NewLexer("@src", chunk))
 	assert.StringEqual(t, err, expectedErr)
 }
 
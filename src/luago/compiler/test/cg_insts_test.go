@@ -186,6 +186,18 @@ jmp(0,1);
 jmp(0,-7)`)
 }
 
+func TestGotoStat(t *testing.T) {
+	testInsts(t, "goto l; f(); ::l::", "[2/0] jmp(0,2); gettabup(0,0,-1); call(0,1,1)")
+	testInsts(t, "::l:: f(); goto l", "[2/0] gettabup(0,0,-1); call(0,1,1); jmp(0,-3)")
+	testInsts(t, "::l:: local a; g = function() return a end; goto l",
+		"[2/1] loadnil(0,0,_); closure(1,0); settabup(0,-1,1); jmp(1,-4)")
+	testInsts(t, "while x do local a; if a then goto c end; ::c:: end",
+		`[2/1]
+gettabup(0,0,-1); test(0,_,0); jmp(0,5);
+loadnil(0,0,_); test(0,_,0); jmp(0,1); jmp(0,0);
+jmp(0,-8)`)
+}
+
 func TestLocalVarDeclStat(t *testing.T) {
 	testInsts(t, "local a", "[2/1] loadnil(0,0,_)")
 	testInsts(t, "local a=nil", "[2/1] loadnil(0,0,_)")
@@ -1,5 +1,6 @@
 package main
 
+import "fmt"
 import "os"
 import . "luago/api"
 import "luago/state"
@@ -8,9 +9,15 @@ func main() {
 	if len(os.Args) > 1 {
 		ls := state.New()
 		ls.OpenLibs()
-		if ls.LoadFile(os.Args[1]) == LUA_OK {
-			ls.PCall(0, LUA_MULTRET, -1)
+		if err := ls.DoFileErr(os.Args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, "lua:", err)
+			if lerr, ok := err.(*LuaError); ok && lerr.Traceback != "" {
+				fmt.Fprintln(os.Stderr, lerr.Traceback)
+			}
+			ls.Close()
+			os.Exit(1)
 		}
+		ls.Close()
 	}
 }
 
@@ -21,7 +28,7 @@ func main() {
 // 	ls := New()         /* create state */
 // 	ls.PushGoFunction(pmain)    /* to call 'pmain' in protected mode */
 // 	ls.PushInteger(argc)        /* 1st argument */
-// 	ls.PushUserData(argv)       /* 2nd argument */
+// 	ls.NewUserData(argv)        /* 2nd argument */
 // 	status := ls.PCall(2, 1, 0) /* do the call */
 // 	result := ls.ToBoolean(-1)  /* get result */
 // 	println(status)
@@ -7,11 +7,11 @@ import . "luago/api"
 // http://www.lua.org/manual/5.3/manual.html#lua_rawlen
 func (self *luaState) RawLen(idx int) uint {
 	val := self.stack.get(idx)
-	switch x := val.(type) {
-	case string:
-		return uint(len(x))
-	case *luaTable:
-		return uint(x.len())
+	switch val.tt {
+	case LUA_TSTRING:
+		return uint(len(val.asString()))
+	case LUA_TTABLE:
+		return uint(val.asTable().len())
 	default:
 		return 0
 	}
@@ -28,6 +28,8 @@ func (self *luaState) TypeName(tp LuaType) string {
 		return "nil"
 	case LUA_TBOOLEAN:
 		return "boolean"
+	case LUA_TLIGHTUSERDATA:
+		return "userdata"
 	case LUA_TNUMBER:
 		return "number"
 	case LUA_TSTRING:
@@ -108,10 +110,17 @@ func (self *luaState) IsThread(idx int) bool {
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isuserdata
-// http://www.lua.org/manual/5.3/manual.html#lua_islightuserdata
 // lua-5.3.4/src/lapi.c#lua_isuserdata()
 func (self *luaState) IsUserData(idx int) bool {
-	return self.Type(idx) == LUA_TUSERDATA
+	t := self.Type(idx)
+	return t == LUA_TUSERDATA || t == LUA_TLIGHTUSERDATA
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_islightuserdata
+// lua-5.3.4/src/lua.h#lua_islightuserdata()
+func (self *luaState) IsLightUserData(idx int) bool {
+	return self.Type(idx) == LUA_TLIGHTUSERDATA
 }
 
 // [-0, +0, –]
@@ -135,8 +144,7 @@ func (self *luaState) IsNumber(idx int) bool {
 // lua-5.3.4/src/lapi.c#lua_isinteger()
 func (self *luaState) IsInteger(idx int) bool {
 	val := self.stack.get(idx)
-	_, ok := val.(int64)
-	return ok
+	return val.tt == LUA_TNUMINT
 }
 
 // [-0, +0, –]
@@ -144,7 +152,7 @@ func (self *luaState) IsInteger(idx int) bool {
 // lua-5.3.4/src/lapi.c#lua_iscfunction()
 func (self *luaState) IsGoFunction(idx int) bool {
 	val := self.stack.get(idx)
-	if c, ok := val.(*closure); ok {
+	if c := val.asClosure(); c != nil {
 		return c.goFunc != nil
 	}
 	return false
@@ -193,12 +201,12 @@ func (self *luaState) ToNumberX(idx int) (float64, bool) {
 func (self *luaState) ToString(idx int) (string, bool) {
 	val := self.stack.get(idx)
 
-	switch x := val.(type) {
-	case string:
-		return x, true
-	case int64, float64:
-		s := fmt.Sprintf("%v", x) // todo
-		self.stack.set(idx, s)
+	switch val.tt {
+	case LUA_TSTRING:
+		return val.asString(), true
+	case LUA_TNUMINT, LUA_TNUMFLT:
+		s := fmt.Sprintf("%v", val.toInterface()) // todo
+		self.stack.set(idx, stringValue(s))
 		return s, true
 	default:
 		return "", false
@@ -209,7 +217,7 @@ func (self *luaState) ToString(idx int) (string, bool) {
 // http://www.lua.org/manual/5.3/manual.html#lua_tocfunction
 func (self *luaState) ToGoFunction(idx int) GoFunction {
 	val := self.stack.get(idx)
-	if c, ok := val.(*closure); ok {
+	if c := val.asClosure(); c != nil {
 		return c.goFunc
 	}
 	return nil
@@ -219,10 +227,8 @@ func (self *luaState) ToGoFunction(idx int) GoFunction {
 // http://www.lua.org/manual/5.3/manual.html#lua_tothread
 func (self *luaState) ToThread(idx int) LuaState {
 	val := self.stack.get(idx)
-	if val != nil {
-		if ls, ok := val.(*luaState); ok {
-			return ls
-		}
+	if ls := val.asThread(); ls != nil {
+		return ls
 	}
 	return nil
 }
@@ -231,21 +237,26 @@ func (self *luaState) ToThread(idx int) LuaState {
 // http://www.lua.org/manual/5.3/manual.html#lua_touserdata
 func (self *luaState) ToUserData(idx int) UserData {
 	val := self.stack.get(idx)
-	if val != nil {
-		if ud, ok := val.(*userData); ok {
-			return ud.data
-		}
+	switch x := val.o.(type) {
+	case *userData:
+		return x.data
+	case lightUserData:
+		return x.p
+	default:
+		return nil
 	}
-	return nil
 }
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_topointer
 func (self *luaState) ToPointer(idx int) interface{} {
 	val := self.stack.get(idx)
-	if val == nil || typeOf(val) < LUA_TTABLE {
+	if lud, ok := val.o.(lightUserData); ok {
+		return lud.p
+	}
+	if typeOf(val) < LUA_TTABLE {
 		return nil
 	} else {
-		return val
+		return val.o
 	}
 }
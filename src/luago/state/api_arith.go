@@ -55,7 +55,7 @@ func (self *luaState) Arith(op ArithOp) {
 	}
 
 	operator := operators[op]
-	if result := _arith(a, b, operator); result != nil {
+	if result, ok := _arith(a, b, operator); ok {
 		self.stack.push(result)
 		return
 	}
@@ -66,35 +66,35 @@ func (self *luaState) Arith(op ArithOp) {
 		return
 	}
 
-	var typeName string
-	if _, ok := convertToFloat(a); !ok {
-		typeName = self.TypeName(typeOf(a))
-	} else {
-		typeName = self.TypeName(typeOf(b))
+	if operator.floatFunc == nil { // bitwise
+		_, ok1 := convertToFloat(a)
+		_, ok2 := convertToFloat(b)
+		if ok1 && ok2 {
+			self.toIntError(a, b)
+		}
+		self.opIntError(a, b, "perform bitwise operation on")
 	}
-	panic("attempt to perform arithmetic on a " + typeName + " value")
+	self.opIntError(a, b, "perform arithmetic on")
 }
 
-func _arith(a, b luaValue, op operator) luaValue {
+func _arith(a, b luaValue, op operator) (luaValue, bool) {
 	if op.floatFunc == nil { // bitwise
 		if x, ok := convertToInteger(a); ok {
 			if y, ok := convertToInteger(b); ok {
-				return op.integerFunc(x, y)
+				return intValue(op.integerFunc(x, y)), true
 			}
 		}
 	} else { // arith
 		if op.integerFunc != nil {
-			if x, ok := a.(int64); ok {
-				if y, ok := b.(int64); ok {
-					return op.integerFunc(x, y)
-				}
+			if a.tt == LUA_TNUMINT && b.tt == LUA_TNUMINT {
+				return intValue(op.integerFunc(a.asInt(), b.asInt())), true
 			}
 		}
 		if x, ok := convertToFloat(a); ok {
 			if y, ok := convertToFloat(b); ok {
-				return op.floatFunc(x, y)
+				return floatValue(op.floatFunc(x, y)), true
 			}
 		}
 	}
-	return nil
+	return nilValue, false
 }
@@ -19,103 +19,220 @@ func (self *luaState) Load(chunk []byte, chunkName, mode string) ThreadStatus {
 	if binchunk.IsBinaryChunk(chunk) {
 		proto = binchunk.Undump(chunk)
 	} else {
-		proto = compiler.Compile(chunkName, string(chunk))
+		var err interface{}
+		if proto, err = _compile(chunkName, string(chunk)); err != nil {
+			self.stack.push(stringValue(_errorMessage(err)))
+			return LUA_ERRSYNTAX
+		}
 	}
 
-	c := newLuaClosure(proto)
+	c := newLuaClosure(newFuncProto(proto))
 	if len(proto.Upvalues) > 0 {
-		env := self.registry.get(LUA_RIDX_GLOBALS)
+		env := self.registry.getInt(LUA_RIDX_GLOBALS)
 		c.upvals[0] = &upvalue{&env}
 	}
-	self.stack.push(c)
+	self.stack.push(closureValue(c))
 	return LUA_OK
 }
 
+// compiler reports syntax errors by panicking
+func _compile(chunkName, chunk string) (proto *binchunk.Prototype, err interface{}) {
+	defer func() { err = recover() }()
+	return compiler.Compile(chunkName, chunk), nil
+}
+
 // [-(nargs+1), +nresults, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_call
 func (self *luaState) Call(nArgs, nResults int) {
-	val := self.stack.get(-(nArgs + 1))
+	self.CallK(nArgs, nResults, nil, nil)
+}
 
-	c, ok := val.(*closure)
-	if !ok {
-		if mf := getMetafield(val, "__call", self); mf != nil {
-			if c, ok = mf.(*closure); ok {
-				self.stack.push(val)
-				self.Insert(-(nArgs + 2))
-				nArgs += 1
-			}
+// [-(nargs + 1), +nresults, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_callk
+// lua-5.3.4/src/lapi.c#lua_callk()
+func (self *luaState) CallK(nArgs, nResults int, ctx KContext, k KFunction) {
+	if caller := self.stack; caller.isGo() { /* called by a Go function? */
+		if k != nil && self.nny == 0 { /* need to prepare continuation? */
+			caller.k = k /* save continuation */
+			caller.ctx = ctx
+			self.callGo(nArgs, nResults) /* do the call */
+		} else { /* no continuation or not yieldable */
+			self.nny++ /* just do the call; pcall and resume restore nny */
+			self.callGo(nArgs, nResults)
+			self.nny--
 		}
+	} else {
+		self.call(nArgs, nResults)
 	}
+}
 
-	if ok {
-		if c.proto != nil {
-			self.callLuaClosure(nArgs, nResults, c)
-		} else {
-			self.callGoClosure(nArgs, nResults, c)
-		}
+// calls a function from a Go function; the nested Go calls are
+// counted (pcall and resume restore the count on errors)
+// lua-5.3.4/src/ldo.c#luaD_call()
+func (self *luaState) callGo(nArgs, nResults int) {
+	if self.nCcalls++; self.nCcalls >= LUAI_MAXCCALLS {
+		self.stackError()
+	}
+	self.call(nArgs, nResults)
+	self.nCcalls--
+}
+
+// lua-5.3.4/src/ldo.c#stackerror()
+func (self *luaState) stackError() {
+	if self.nCcalls == LUAI_MAXCCALLS {
+		panic("C stack overflow")
+	} else if self.nCcalls >= LUAI_MAXCCALLS+LUAI_MAXCCALLS>>3 {
+		/* error while handling stack error */
+		panic(&LuaError{Status: LUA_ERRERR, Value: "error in error handling"})
+	}
+}
+
+func (self *luaState) call(nArgs, nResults int) {
+	c, nArgs := self.tryFuncTM(nArgs)
+	if c.proto != nil {
+		self.callLuaClosure(nArgs, nResults, c)
 	} else {
-		typeName := self.TypeName(typeOf(val))
-		panic("attempt to call a " + typeName + " value")
+		self.callGoClosure(nArgs, nResults, c)
 	}
 }
 
-func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
-	// create new lua stack
-	newStack := newLuaStack(nArgs+LUA_MINSTACK, self)
-	newStack.closure = c
+// returns the function to be called with the nArgs values on the
+// top of the stack, trying the '__call' metamethod of non-functions
+// lua-5.3.4/src/ldo.c#tryfuncTM()
+func (self *luaState) tryFuncTM(nArgs int) (*closure, int) {
+	val := self.stack.get(-(nArgs + 1))
 
-	// pass args, pop func
-	if nArgs > 0 {
-		args := self.stack.popN(nArgs)
-		newStack.pushN(args, nArgs)
+	c := val.asClosure()
+	if c == nil {
+		if c = getMetafield(val, "__call", self).asClosure(); c != nil {
+			self.stack.push(val)
+			self.Insert(-(nArgs + 2))
+			nArgs += 1
+		}
 	}
-	self.stack.pop()
 
-	// run closure
-	self.pushLuaStack(newStack)
-	r := c.goFunc(self)
-	self.popLuaStack()
+	if c == nil {
+		self.objTypeError(val, "call")
+	}
+	return c, nArgs
+}
 
-	// return results
-	if nResults != 0 {
-		results := newStack.popN(r)
-		self.stack.pushN(results, nResults)
+// Calls the function like Call, with LUA_MULTRET results. A Lua function
+// replaces the running frame instead of nesting in it, so that tail calls
+// use no stack; reports whether it did so.
+// lua-5.3.4/src/lvm.c#OP_TAILCALL
+func (self *luaState) TailCall(nArgs int) bool {
+	c, nArgs := self.tryFuncTM(nArgs)
+	if c.proto == nil { /* Go function? */
+		self.callGoClosure(nArgs, LUA_MULTRET, c)
+		return false
 	}
+
+	/* move down function and arguments */
+	stack := self.stack
+	stack.closeUpvalues(0)
+	fn, end := stack.fn, stack.base+stack.top
+	copy(self.slots[fn:], self.slots[end-nArgs-1:end])
+	clear(self.slots[fn+nArgs+1 : end])
+	nResults := stack.nResults
+	self.popLuaStack()
+	self.newLuaFrame(fn, nArgs, nResults, c).tailCall = true
+	self.checkContext()
+	self.hookCall()
+	return true
+}
+
+func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
+	caller := self.stack
+	fn := caller.base + caller.top - nArgs - 1
+	stack := self.pushLuaStack(fn, fn+1, nArgs+LUA_MINSTACK)
+	stack.closure = c
+	stack.top = nArgs
+	stack.nResults = nResults
+	self.checkContext()
+	self.hookCall()
+	r := c.goFunc(self)
+	self.postCall(r)
 }
 
 func (self *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
+	caller := self.stack
+	self.newLuaFrame(caller.base+caller.top-nArgs-1, nArgs, nResults, c)
+	self.checkContext()
+	self.hookCall()
+	self.runLuaClosure()
+	/* the frame may have been replaced by tail calls */
+	self.postCall(self.stack.top - self.RegisterCount())
+}
+
+// pushes the frame of c, called with the function at index fn of the
+// stack and its nArgs arguments above it; the arguments stay in place
+// lua-5.3.4/src/ldo.c#luaD_precall()
+func (self *luaState) newLuaFrame(fn, nArgs, nResults int, c *closure) *luaStack {
 	nRegs := int(c.proto.MaxStackSize)
 	nParams := int(c.proto.NumParams)
 	isVararg := c.proto.IsVararg == 1
 
-	// create new lua stack
-	newStack := newLuaStack(nRegs+LUA_MINSTACK, self)
-	newStack.closure = c
-
-	// pass args, pop func
-	funcAndArgs := self.stack.popN(nArgs + 1)
-	newStack.pushN(funcAndArgs[1:], nParams)
-	newStack.top = nRegs
-	if nArgs > nParams && isVararg {
-		newStack.varargs = funcAndArgs[nParams+1:]
+	base, nVarargs := fn+1, 0
+	if nArgs > nParams {
+		if isVararg { /* move fixed parameters above the extra arguments */
+			base, nVarargs = fn+1+nArgs, nArgs-nParams
+			self.growStack(base + nRegs + LUA_MINSTACK)
+			copy(self.slots[base:], self.slots[fn+1:fn+1+nParams])
+			clear(self.slots[fn+1 : fn+1+nParams])
+		} else { /* drop extra arguments */
+			clear(self.slots[fn+1+nParams : fn+1+nArgs])
+		}
 	}
 
-	// run closure
-	self.pushLuaStack(newStack)
-	self.runLuaClosure()
+	stack := self.pushLuaStack(fn, base, nRegs+LUA_MINSTACK)
+	stack.closure = c
+	stack.nVarargs = nVarargs
+	stack.top = nRegs
+	stack.nResults = nResults
+	return stack
+}
+
+// pops the finished frame and moves its last nRets values
+// to the slot of the function, as results for the caller
+// lua-5.3.4/src/ldo.c#luaD_poscall()
+func (self *luaState) postCall(nRets int) {
+	stack := self.stack
+	self.hookReturn()
 	self.popLuaStack()
 
-	// return results
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		self.stack.check(len(results))
-		self.stack.pushN(results, nResults)
+	first := stack.base + stack.top - nRets
+	end, res := stack.base+stack.top, stack.fn
+	wanted := stack.nResults
+	if wanted < 0 { /* LUA_MULTRET */
+		wanted = nRets
+	}
+	caller := self.stack
+	caller.top = res - caller.base
+	caller.check(wanted) /* may move the stack */
+	slots := self.slots
+	n := copy(slots[res:res+min(wanted, nRets)], slots[first:])
+	clear(slots[res+n : res+wanted])
+	if from := res + wanted; from < end {
+		clear(slots[from:end])
 	}
+	caller.top += wanted
 }
 
 func (self *luaState) runLuaClosure() {
-	for {
+	for n := 1; ; n++ {
+		if n%ctxCheckInterval == 0 {
+			self.checkContext()
+		}
+		if max := self.limits.MaxInstructions; max > 0 {
+			if self.nInsts++; self.nInsts > max {
+				self.quotaError("instruction limit exceeded")
+			}
+		}
 		inst := vm.Instruction(self.Fetch())
+		if self.hookMask&(LUA_MASKLINE|LUA_MASKCOUNT) != 0 {
+			self.traceExec()
+		}
 		inst.Execute(self)
 
 		// indent := fmt.Sprintf("%%%ds", self.callDepth*2)
@@ -130,55 +247,102 @@ func (self *luaState) runLuaClosure() {
 
 // Calls a function in protected mode.
 // http://www.lua.org/manual/5.3/manual.html#lua_pcall
-func (self *luaState) PCall(nArgs, nResults, msgh int) (status ThreadStatus) {
-	status = LUA_ERRRUN
-	caller := self.stack
+func (self *luaState) PCall(nArgs, nResults, msgh int) ThreadStatus {
+	status, _ := self.pcall(nArgs, nResults, msgh, false)
+	return status
+}
+
+// [-(nargs + 1), +nresults, –]
+// Like PCall, but returns the error (a *LuaError)
+// instead of pushing the error object.
+func (self *luaState) PCallErr(nArgs, nResults, msgh int) error {
+	if _, err := self.pcall(nArgs, nResults, msgh, true); err != nil {
+		self.stack.pop()
+		return err
+	}
+	return nil
+}
+
+// calls a function in protected mode; with trace, errors record the
+// stack frames where they were raised (only hosts get to see them)
+func (self *luaState) pcall(nArgs, nResults, msgh int, trace bool) (status ThreadStatus, err *LuaError) {
+	caller, oldTop := self.stack, self.stack.top-nArgs-1
+	oldNny, oldNCcalls := self.nny, self.nCcalls
+	var handler luaValue
+	if msgh != 0 {
+		handler = self.stack.get(msgh)
+	}
+	if self.nPCalls == 0 && self.coCaller == nil {
+		self.nInsts = 0
+	}
+	self.nPCalls++
 
 	// catch error
 	defer func() {
-		if r := recover(); r != nil { // todo
-			if msgh < 0 {
-				panic(_getErrObj(r))
-			} else if msgh > 0 {
-				panic("todo: msgh > 0")
-			} else {
-				for self.stack != caller {
-					self.popLuaStack()
-				}
-				self.stack.push(_getErrObj(r))
+		self.nPCalls--
+		if r := recover(); r != nil {
+			if _, ok := r.(*quotaError); ok && self.nPCalls > 0 {
+				panic(r) // not catchable by scripts
 			}
+			err = self.toLuaError(r, trace)
+			if !handler.isNil() && err.Status == LUA_ERRRUN {
+				err = self.callMsgh(handler, err)
+			}
+			self.unwind(caller, oldTop)
+			self.nny, self.nCcalls = oldNny, oldNCcalls
+			self.shrinkStack()
+			self.stack.check(1)
+			self.stack.push(valueOf(err.Value))
+			status = err.Status
 		}
 	}()
 
 	self.Call(nArgs, nResults)
-	status = LUA_OK
-	return
+	return LUA_OK, nil
 }
 
-func _getErrObj(err interface{}) luaValue {
-	if t, ok := err.(*luaTable); ok {
-		return t.get("_ERR")
-	}
-
-	// runtime error
-	switch x := err.(type) {
-	case string:
-		return x
-	case error:
-		return x.Error()
-	default:
-		return "unknown error"
+// calls the message handler at the error point, before the stack
+// is unwound; errors in the handler itself produce LUA_ERRERR with
+// the message "error in error handling", as in PUC Lua
+func (self *luaState) callMsgh(handler luaValue, err *LuaError) *LuaError {
+	self.stack.check(2)
+	self.stack.push(handler)
+	self.stack.push(valueOf(err.Value))
+	self.nny++ /* handlers cannot yield */
+	defer func() { self.nny-- }()
+	if _, herr := self.pcall(1, 1, 0, false); herr != nil {
+		if herr.Status != LUA_ERRRUN && herr.Status != LUA_ERRERR {
+			return herr /* memory errors are reported as such */
+		}
+		err.Status, err.Value, err.Cause = LUA_ERRERR, "error in error handling", nil
+		return err
 	}
-}
-
-// [-(nargs + 1), +nresults, e]
-// http://www.lua.org/manual/5.3/manual.html#lua_callk
-func (self *luaState) CallK() {
-	panic("todo: CallK!")
+	err.Value = self.stack.pop().toInterface()
+	return err
 }
 
 // [-(nargs + 1), +(nresults|1), –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pcallk
-func (self *luaState) PCallK() {
-	panic("todo: PCallK!")
+// lua-5.3.4/src/lapi.c#lua_pcallk()
+func (self *luaState) PCallK(nArgs, nResults, msgh int,
+	ctx KContext, k KFunction) ThreadStatus {
+
+	caller := self.stack
+	if k == nil || self.nny > 0 || !caller.isGo() { /* no continuation or not yieldable? */
+		return self.PCall(nArgs, nResults, msgh) /* just do a conventional protected call */
+	}
+
+	/* prepare continuation (call is already protected by 'resume') */
+	caller.k = k /* save continuation */
+	caller.ctx = ctx
+	caller.handler = nilValue
+	if msgh != 0 {
+		caller.handler = self.stack.get(msgh)
+	}
+	caller.oldTop = caller.top - nArgs - 1
+	caller.ypcall = true /* function can do error recovery */
+	self.callGo(nArgs, nResults)
+	caller.ypcall = false
+	caller.handler = nilValue
+	return LUA_OK
 }
@@ -0,0 +1,122 @@
+package state
+
+import "strings"
+import "testing"
+import "assert"
+import . "luago/api"
+
+func TestTailCall(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetLimits(Limits{MaxCallDepth: 100})
+
+	err := ls.DoStringErr(`
+		local even, odd
+		function even(n) if n == 0 then return true end return odd(n - 1) end
+		function odd(n) if n == 0 then return false end return even(n - 1) end
+		assert(even(100000) and not odd(100000))
+
+		local t = setmetatable({}, {__call = function(self, n, acc)
+			if n == 0 then return acc end
+			return self(n - 1, acc + n)
+		end})
+		assert(t(10000, 0) == 50005000)
+
+		local function count(n, ...)
+			if n == 0 then return select("#", ...) end
+			return count(n - 1, n, ...)
+		end
+		assert(count(50) == 50)
+
+		local function f() return debug.getinfo(1, "t").istailcall end
+		local function g() return f() end
+		assert(g() == true and f() == false)
+
+		local function h() error("boom") end
+		local function k() return h() end
+		local ok, tb = xpcall(k, debug.traceback)
+		assert(not ok)
+		return tb`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, _ := ls.ToString(-1)
+	if !strings.Contains(tb, "\n\t(...tail calls...)") || strings.Contains(tb, "'k'") {
+		t.Errorf("traceback: %s", tb)
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local function rec() return 1 + rec() end
+		local ok, msg = pcall(rec)
+		assert(not ok and msg:find(":2: stack overflow$"), msg)
+
+		ok, msg = xpcall(rec, function(m)
+			assert(m:find("stack overflow"))
+			local ok, e = pcall(rec)
+			assert(not ok and e == "error in error handling", e)
+			return "handled"
+		end)
+		assert(not ok and msg == "handled")
+
+		local function gsub(s) return (string.gsub(s, ".", gsub)) end
+		ok, msg = pcall(gsub, "x")
+		assert(not ok and msg == "C stack overflow", msg)
+
+		ok, msg = pcall(table.unpack, {}, 1, 1e7)
+		assert(not ok and msg:find("too many results to unpack"))
+		assert(select("#", table.unpack({}, 1, 1e5)) == 1e5)`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.IntEqual(t, ls.GetTop(), 0)
+	if ls.CheckStack(LUAI_MAXSTACK + 1) {
+		t.Error("CheckStack: stack overflow expected")
+	}
+	if !ls.CheckStack(1000) {
+		t.Error("CheckStack: failed")
+	}
+}
+
+func TestCallFrames(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local function counter()
+			local n = 0
+			return function() n = n + 1 return n end, function() return n end
+		end
+		local inc, get = counter()
+		inc() inc()
+		assert(get() == 2)
+
+		local function deep(n, f) if n == 0 then return f() end return deep(n - 1, f) + 0 end
+		local x = 1
+		local function getx() return x end
+		x = 2 -- the stack is moved while x is still open
+		assert(deep(10000, getx) == 2)
+
+		local function va(a, b, ...) return b, a, select("#", ...), ... end
+		local r = {va(1, 2, 3, nil, 5)}
+		assert(r[1] == 2 and r[2] == 1 and r[3] == 3 and r[4] == 3 and r[6] == 5)
+		local p, q, s = va(1)
+		assert(p == nil and q == 1 and s == 0)
+
+		local ok = pcall(nil, 1, 2)
+		assert(not ok)`)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.IntEqual(t, ls.GetTop(), 0)
+
+	ls.PushNil()
+	ls.PushInteger(1)
+	assert.IntEqual(t, int(ls.PCall(1, 0, 0)), int(LUA_ERRRUN))
+	assert.IntEqual(t, ls.GetTop(), 1) // only the error object
+}
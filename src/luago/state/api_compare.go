@@ -36,36 +36,28 @@ func (self *luaState) Compare(idx1, idx2 int, op CompareOp) bool {
 }
 
 func (self *luaState) eq(a, b luaValue, raw bool) bool {
-	switch x := a.(type) {
-	case nil:
-		return b == nil
-	case bool:
-		y, ok := b.(bool)
-		return ok && x == y
-	case string:
-		y, ok := b.(string)
-		return ok && x == y
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x == y
-		case float64:
-			return float64(x) == y
+	switch a.tt {
+	case LUA_TNUMINT:
+		switch b.tt {
+		case LUA_TNUMINT:
+			return a.asInt() == b.asInt()
+		case LUA_TNUMFLT:
+			return float64(a.asInt()) == b.asFloat()
 		default:
 			return false
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x == y
-		case int64:
-			return x == float64(y)
+	case LUA_TNUMFLT:
+		switch b.tt {
+		case LUA_TNUMFLT:
+			return a.asFloat() == b.asFloat()
+		case LUA_TNUMINT:
+			return a.asFloat() == float64(b.asInt())
 		default:
 			return false
 		}
-	case *luaTable:
-		if y, ok := b.(*luaTable); ok && x != y && !raw {
-			if result, ok := callMetamethod(x, y, "__eq", self); ok {
+	case LUA_TTABLE, LUA_TUSERDATA:
+		if b.tt == a.tt && a != b && !raw {
+			if result, ok := callMetamethod(a, b, "__eq", self); ok {
 				return convertToBoolean(result)
 			}
 		}
@@ -76,70 +68,65 @@ func (self *luaState) eq(a, b luaValue, raw bool) bool {
 }
 
 func (self *luaState) lt(a, b luaValue) bool {
-	switch x := a.(type) {
-	case string:
-		y, ok := b.(string)
-		return ok && x < y
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x < y
-		case float64:
-			return float64(x) < y
-		default:
-			return false
+	switch a.tt {
+	case LUA_TSTRING:
+		if b.tt == LUA_TSTRING {
+			return a.asString() < b.asString()
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x < y
-		case int64:
-			return x < float64(y)
-		default:
-			return false
+	case LUA_TNUMINT:
+		switch b.tt {
+		case LUA_TNUMINT:
+			return a.asInt() < b.asInt()
+		case LUA_TNUMFLT:
+			return float64(a.asInt()) < b.asFloat()
 		}
-	default:
-		if result, ok := callMetamethod(a, b, "__lt", self); ok {
-			return convertToBoolean(result)
+	case LUA_TNUMFLT:
+		switch b.tt {
+		case LUA_TNUMFLT:
+			return a.asFloat() < b.asFloat()
+		case LUA_TNUMINT:
+			return a.asFloat() < float64(b.asInt())
 		}
-		typeName1 := self.TypeName(typeOf(a))
-		typeName2 := self.TypeName(typeOf(b))
-		panic("attempt to compare " + typeName1 + " with " + typeName2)
 	}
+	if result, ok := callMetamethod(a, b, "__lt", self); ok {
+		return convertToBoolean(result)
+	}
+	typeName1 := self.TypeName(typeOf(a))
+	typeName2 := self.TypeName(typeOf(b))
+	panic("attempt to compare " + typeName1 + " with " + typeName2)
 }
 
 func (self *luaState) le(a, b luaValue) bool {
-	switch x := a.(type) {
-	case string:
-		y, ok := b.(string)
-		return ok && x <= y
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x <= y
-		case float64:
-			return float64(x) <= y
-		default:
-			return false
+	switch a.tt {
+	case LUA_TSTRING:
+		if b.tt == LUA_TSTRING {
+			return a.asString() <= b.asString()
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x <= y
-		case int64:
-			return x <= float64(y)
-		default:
-			return false
+	case LUA_TNUMINT:
+		switch b.tt {
+		case LUA_TNUMINT:
+			return a.asInt() <= b.asInt()
+		case LUA_TNUMFLT:
+			return float64(a.asInt()) <= b.asFloat()
 		}
-	default:
-		if result, ok := callMetamethod(a, b, "__le", self); ok {
-			return convertToBoolean(result)
+	case LUA_TNUMFLT:
+		switch b.tt {
+		case LUA_TNUMFLT:
+			return a.asFloat() <= b.asFloat()
+		case LUA_TNUMINT:
+			return a.asFloat() <= float64(b.asInt())
 		}
-		if result, ok := callMetamethod(b, a, "__lt", self); ok {
-			return !convertToBoolean(result)
-		}
-		typeName1 := self.TypeName(typeOf(a))
-		typeName2 := self.TypeName(typeOf(b))
-		panic("attempt to compare " + typeName1 + " with " + typeName2)
 	}
+	if result, ok := callMetamethod(a, b, "__le", self); ok {
+		return convertToBoolean(result)
+	}
+	self.stack.leq = true /* mark it is doing 'lt' for 'le' */
+	result, ok := callMetamethod(b, a, "__lt", self)
+	self.stack.leq = false
+	if ok {
+		return !convertToBoolean(result)
+	}
+	typeName1 := self.TypeName(typeOf(a))
+	typeName2 := self.TypeName(typeOf(b))
+	panic("attempt to compare " + typeName1 + " with " + typeName2)
 }
@@ -0,0 +1,49 @@
+package state
+
+import "context"
+import "reflect"
+import . "luago/api"
+
+// number of instructions executed between two checks of the context
+const ctxCheckInterval = 1024
+
+// [-0, +0, –]
+// Sets the context checked by calls made on this state (and on threads
+// created or resumed by it). When the context is done, the running
+// function raises an error whose error object is ctx.Err().
+func (self *luaState) SetContext(ctx context.Context) {
+	self.ctx = ctx
+}
+
+// [-0, +0, –]
+// Returns the context of calls made on this state, or
+// context.Background() if no context was set.
+func (self *luaState) Context() context.Context {
+	if self.ctx == nil {
+		return context.Background()
+	}
+	return self.ctx
+}
+
+// [-(nargs + 1), +(nresults|1), –]
+// Calls a function in protected mode with ctx as the context of the call.
+func (self *luaState) PCallContext(ctx context.Context, nArgs, nResults, msgh int) ThreadStatus {
+	oldCtx := self.ctx
+	self.ctx = ctx
+	defer func() { self.ctx = oldCtx }()
+	return self.PCall(nArgs, nResults, msgh)
+}
+
+// raises an error if the context of the state is done
+func (self *luaState) checkContext() {
+	if self.ctx == nil {
+		return
+	}
+	select {
+	case <-self.ctx.Done():
+		err := self.ctx.Err()
+		self.pushGoObject(reflect.ValueOf(err))
+		self.Error()
+	default:
+	}
+}
@@ -0,0 +1,49 @@
+package state
+
+import "context"
+import "testing"
+import "time"
+import "assert"
+import . "luago/api"
+
+func TestPCallContext(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ls.LoadString(`while true do end`)
+	status := ls.PCallContext(ctx, 0, 0, 0)
+	assert.IntEqual(t, status, LUA_ERRRUN)
+	if ls.ToUserData(-1) != context.DeadlineExceeded {
+		t.Errorf("error object should be ctx.Err()")
+	}
+	ls.Pop(1)
+
+	// the error is catchable, but raised again while ctx is done
+	ctx, cancel = context.WithCancel(context.Background())
+	ls.PushGoFunction(func(ls LuaState) int {
+		if ls.Context() != ctx {
+			t.Errorf("Go functions should see the context")
+		}
+		cancel()
+		return 0
+	})
+	ls.SetGlobal("cancel")
+	ls.LoadString(`
+		local ok, err = pcall(function()
+			cancel()
+			while true do end
+		end)
+		caught = err
+		while true do end`)
+	status = ls.PCallContext(ctx, 0, 0, 0)
+	assert.IntEqual(t, status, LUA_ERRRUN)
+	ls.GetGlobal("caught")
+	if ls.ToUserData(-1) != context.Canceled {
+		t.Errorf("error should be catchable")
+	}
+	if ls.Context() != context.Background() {
+		t.Errorf("PCallContext should restore the context")
+	}
+}
@@ -1,65 +1,230 @@
 package state
 
 import . "luago/api"
+import "luago/vm"
+
+// unwinds the Go stack of a yielding coroutine up to Resume;
+// its Lua frames are left on the luaStack chain
+type yieldSignal struct{}
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_newthread
 // lua-5.3.4/src/lstate.c#lua_newthread()
 func (self *luaState) NewThread() LuaState {
-	t := &luaState{registry: self.registry}
-	t.pushLuaStack(newLuaStack(LUA_MINSTACK, t))
-	self.stack.push(t)
+	t := &luaState{registry: self.registry, gc: self.gc, ctx: self.ctx, limits: self.limits, nny: 1}
+	t.SetHook(self.hook, self.hookMask, self.baseHookCount)
+	t.pushLuaStack(-1, 0, LUA_MINSTACK)
+	self.stack.push(threadValue(t))
 	return t
 }
 
 // [-?, +?, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_resume
+// lua-5.3.4/src/ldo.c#lua_resume()
 func (self *luaState) Resume(from LuaState, nArgs int) ThreadStatus {
+	self.checkOpen()
 	lsFrom := from.(*luaState)
-	if lsFrom.coChan == nil {
-		lsFrom.coChan = make(chan int)
+	if self.coStatus == LUA_OK { /* may be starting a coroutine */
+		if self.stack.prev != nil { /* not in base level? */
+			return self.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+	} else if self.coStatus != LUA_YIELD {
+		return self.resumeError("cannot resume dead coroutine", nArgs)
+	}
+	if lsFrom.nCcalls+1 >= LUAI_MAXCCALLS {
+		return self.resumeError("C stack overflow", nArgs)
 	}
 
-	if self.coChan == nil {
-		// start coroutine
-		self.coChan = make(chan int)
-		self.coCaller = lsFrom
-		go func() {
-			self.coStatus = self.PCall(nArgs, -1, 0)
-			lsFrom.coChan <- 1
-		}()
-	} else {
-		// resume coroutine
-		self.coStatus = LUA_OK
-		self.coChan <- 1
+	self.ctx = lsFrom.ctx
+	self.limits = lsFrom.limits
+	self.nInsts = lsFrom.nInsts
+	self.coCaller = lsFrom
+	self.nCcalls = lsFrom.nCcalls + 1
+	oldNny := self.nny
+	self.nny = 0 /* allow yields */
+	status := self.resume(nArgs)
+	self.nny = oldNny
+	self.nCcalls = 0
+	self.coCaller = nil
+	lsFrom.nInsts = self.nInsts
+
+	if status == LUA_ERRQUOTA && lsFrom.nPCalls > 0 {
+		lsFrom.quotaError("%s", self.stack.get(-1).toInterface())
 	}
+	return status
+}
 
-	<-lsFrom.coChan // wait coroutine to finish or yield
-	return self.coStatus
+// lua-5.3.4/src/ldo.c#resume_error()
+func (self *luaState) resumeError(msg string, nArgs int) ThreadStatus {
+	self.stack.popN(nArgs)            /* remove args from the stack */
+	self.stack.push(stringValue(msg)) /* push error message */
+	return LUA_ERRRUN
+}
+
+// runs the coroutine until it finishes, yields or raises an error
+// that no pcall can recover; then the coroutine is dead and its
+// frames are left in place
+// lua-5.3.4/src/ldo.c#lua_resume()
+func (self *luaState) resume(nArgs int) ThreadStatus {
+	status, err := self.runProtected(func() { self._resume(nArgs) })
+	for err != nil {
+		var ok bool
+		if err, ok = self.recover(err); !ok {
+			break
+		}
+		/* unroll continuation */
+		errStatus := err.Status
+		status, err = self.runProtected(func() {
+			self.finishGoCall(errStatus)
+			self.unroll()
+		})
+	}
+	if err != nil { /* unrecoverable error? */
+		self.coStatus = status /* mark thread as 'dead' */
+		self.stack.check(1)
+		self.stack.push(valueOf(err.Value))
+	}
+	return status
+}
+
+// lua-5.3.4/src/ldo.c#resume()
+func (self *luaState) _resume(nArgs int) {
+	if self.coStatus == LUA_OK { /* starting a coroutine? */
+		self.Call(nArgs, LUA_MULTRET)
+		return
+	}
+
+	/* resuming from previous yield */
+	self.coStatus = LUA_OK /* mark that it is running (again) */
+	stack := self.stack
+	args := stack.popN(nArgs)
+	stack.check(len(stack.saved) + nArgs)
+	stack.pushN(stack.saved, -1) /* restore values below the yielded ones */
+	stack.pushN(args, nArgs)
+	stack.saved = nil
+	if stack.k != nil { /* does it have a continuation function? */
+		nArgs = stack.k(self, LUA_YIELD, stack.ctx) /* call continuation */
+	}
+	self.postCall(nArgs) /* finish the Go function that yielded */
+	self.unroll()
+}
+
+// runs f, catching errors and yields
+// lua-5.3.4/src/ldo.c#luaD_rawrunprotected()
+func (self *luaState) runProtected(f func()) (status ThreadStatus, err *LuaError) {
+	oldNCcalls := self.nCcalls
+	defer func() {
+		self.nCcalls = oldNCcalls
+		if r := recover(); r != nil {
+			if _, ok := r.(yieldSignal); ok {
+				status = LUA_YIELD
+			} else {
+				err = self.toLuaError(r, false)
+				status = err.Status
+			}
+		}
+	}()
+
+	f()
+	return LUA_OK, nil
+}
+
+// completes the protected call of a yieldable pcall interrupted by
+// err, leaving the error object on its stack
+// lua-5.3.4/src/ldo.c#recover()
+func (self *luaState) recover(err *LuaError) (*LuaError, bool) {
+	if err.Status == LUA_ERRQUOTA {
+		return err, false /* not catchable by scripts */
+	}
+	stack := self.stack
+	for stack != nil && !stack.ypcall {
+		stack = stack.prev
+	}
+	if stack == nil {
+		return err, false /* no recovery point */
+	}
+
+	if !stack.handler.isNil() && err.Status == LUA_ERRRUN {
+		err = self.callMsgh(stack.handler, err)
+	}
+	self.unwind(stack, stack.oldTop)
+	self.shrinkStack()
+	self.stack.check(1)
+	self.stack.push(valueOf(err.Value))
+	self.nny = 0     /* should be zero to be yieldable */
+	return err, true /* continue running the coroutine */
+}
+
+// executes the frames interrupted by a yield until the coroutine
+// returns to its base level
+// lua-5.3.4/src/ldo.c#unroll()
+func (self *luaState) unroll() {
+	for self.stack.prev != nil { /* something in the stack */
+		if self.stack.isGo() { /* Go function? */
+			self.finishGoCall(LUA_YIELD) /* complete its execution */
+		} else { /* Lua function */
+			self.finishOp() /* finish interrupted instruction */
+			self.runLuaClosure()
+			self.postCall(self.stack.top - int(self.stack.closure.proto.MaxStackSize))
+		}
+	}
+}
+
+// completes the Go function interrupted by a yield in a call made
+// by CallK or PCallK, by calling its continuation
+// lua-5.3.4/src/ldo.c#finishCcall()
+func (self *luaState) finishGoCall(status ThreadStatus) {
+	stack := self.stack
+	stack.ypcall = false /* continuation is also inside the pcall */
+	stack.handler = nilValue
+	n := stack.k(self, status, stack.ctx) /* call continuation */
+	self.postCall(n)
+}
+
+// completes the instruction of the current Lua function that was
+// interrupted by a call, whose results are on the top of the stack
+// lua-5.3.4/src/lvm.c#luaV_finishOp()
+func (self *luaState) finishOp() {
+	if self.stack.leq { /* "<=" using "<" instead? */
+		self.stack.leq = false
+		self.stack.push(boolValue(!convertToBoolean(self.stack.pop()))) /* negate result */
+	}
+	inst := vm.Instruction(self.stack.closure.proto.Code[self.stack.pc-1])
+	inst.Finish(self)
 }
 
 // [-?, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_yield
 func (self *luaState) Yield(nResults int) int {
-	self.coStatus = LUA_YIELD
-	self.coCaller.coChan <- 1
-	<-self.coChan
-	return self.GetTop()
+	return self.YieldK(nResults, nil, nil)
 }
 
 // [-?, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_yieldk
-func (self *luaState) YieldK() {
-	panic("todo!")
+// lua-5.3.4/src/ldo.c#lua_yieldk()
+func (self *luaState) YieldK(nResults int, ctx KContext, k KFunction) int {
+	if self.nny > 0 || self.coCaller == nil {
+		if self.coCaller != nil {
+			panic("attempt to yield across a C-call boundary")
+		} else {
+			panic("attempt to yield from outside a coroutine")
+		}
+	}
+
+	self.coStatus = LUA_YIELD
+	stack := self.stack
+	stack.k = k /* continuation, if any */
+	stack.ctx = ctx
+	results := stack.popN(nResults)
+	stack.saved = stack.popN(stack.top) /* protect stack below results */
+	stack.pushN(results, nResults)
+	panic(yieldSignal{})
 }
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isyieldable
 func (self *luaState) IsYieldable() bool {
-	if self.isMainThread() {
-		return false
-	}
-	return self.coStatus != LUA_YIELD // todo
+	return self.nny == 0
 }
 
 // [-0, +0, –]
@@ -0,0 +1,237 @@
+package state
+
+import "runtime"
+import "testing"
+import "assert"
+import . "luago/api"
+
+func TestCoroutine(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local function gen(n)
+			for i = 1, n do coroutine.yield(i) end
+			return "done"
+		end
+		local co = coroutine.create(gen)
+		local s = ""
+		while true do
+			local ok, v = coroutine.resume(co, 3)
+			assert(ok)
+			s = s .. v
+			if coroutine.status(co) == "dead" then break end
+		end
+		assert(s == "123done", s)
+		local ok, msg = coroutine.resume(co)
+		assert(not ok and msg == "cannot resume dead coroutine")
+
+		-- values passed both ways
+		co = coroutine.create(function(a, b)
+			local c, d = coroutine.yield(a + b)
+			return c * d
+		end)
+		assert(select(2, coroutine.resume(co, 1, 2)) == 3)
+		assert(select(2, coroutine.resume(co, 3, 4)) == 12)
+
+		-- errors leave the coroutine dead
+		co = coroutine.create(function() local x = nil; x.y = 1 end)
+		ok, msg = coroutine.resume(co)
+		assert(not ok and msg:find("attempt to index a nil value"))
+		assert(coroutine.status(co) == "dead")
+
+		-- Go functions calling Lua without continuations cannot be suspended
+		co = coroutine.create(function()
+			return tostring(setmetatable({}, {__tostring = coroutine.yield}))
+		end)
+		ok, msg = coroutine.resume(co)
+		assert(not ok and msg == "attempt to yield across a C-call boundary")
+		assert(coroutine.status(co) == "dead")
+		ok, msg = pcall(coroutine.yield)
+		assert(not ok and msg == "attempt to yield from outside a coroutine")`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestYieldInMetamethods(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local y = coroutine.yield
+		local mt = {
+			__add = function(a, b) return y("add") end,
+			__index = function(t, k) return y("index") end,
+			__lt = function(a, b) return y("lt") end,
+			__concat = function(a, b) return y("concat") end,
+			__len = function(a) return y("len") end,
+		}
+		local a = setmetatable({}, mt)
+		local co = coroutine.create(function()
+			local r = {}
+			r[1] = a + 1
+			r[2] = a.x
+			r[3] = a < a
+			r[4] = a <= a -- __lt fallback negates the result
+			r[5] = "x" .. a .. "y"
+			r[6] = #a
+			for k in y do r[7] = k; break end
+			return r
+		end)
+		local events = {}
+		local resumeValues = {10, "v", true, true, "C", 6, "it"}
+		local ok, ev = coroutine.resume(co)
+		for i = 1, #resumeValues do
+			events[i] = tostring(ev)
+			ok, ev = coroutine.resume(co, resumeValues[i])
+			assert(ok, ev)
+		end
+		assert(coroutine.status(co) == "dead")
+		assert(table.concat(events, " ") == "add index lt lt concat len nil")
+		assert(ev[1] == 10 and ev[2] == "v" and ev[3] == true)
+		assert(ev[4] == false and ev[5] == "xC" and ev[6] == 6)
+		assert(ev[7] == "it")`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCoroutineGoroutines(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	n := runtime.NumGoroutine()
+	ls.DoString(`
+		for i = 1, 100 do
+			local co = coroutine.create(function() coroutine.yield() end)
+			coroutine.resume(co) -- abandoned while suspended
+		end`)
+	assert.IntEqual(t, runtime.NumGoroutine(), n)
+
+	co := ls.NewThread()
+	ls.Pop(1)
+	co.PushGoFunction(func(ls LuaState) int {
+		ls.PushInteger(42)
+		return ls.Yield(1)
+	})
+	assert.IntEqual(t, co.Resume(ls, 0), LUA_YIELD)
+	assert.IntEqual(t, int(co.ToInteger(-1)), 42)
+	co.Pop(1)
+	co.PushString("x")
+	assert.IntEqual(t, co.Resume(ls, 1), LUA_OK)
+	s, _ := co.ToString(-1)
+	assert.StringEqual(t, s, "x")
+}
+
+func TestContinuations(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local y = coroutine.yield
+		local co = coroutine.create(function()
+			local r = {}
+			r[1] = select(2, pcall(y, "pcall"))
+			r[2] = select(2, pcall(function() y("error"); error("x", 0) end))
+			r[3] = select(2, xpcall(function() y("xpcall"); error("x", 0) end,
+				function(m) return m .. "!" end))
+			local t = {3, 1, 2}
+			table.sort(t, function(a, b) return a < b end)
+			r[4] = table.concat(t)
+			-- as in 5.3, comparators of table.sort cannot yield
+			local ok, msg = pcall(table.sort, t, function() y("sort") end)
+			assert(not ok and msg == "attempt to yield across a C-call boundary")
+			return table.concat(r, " ")
+		end)
+		local events, ok, ev = {}, coroutine.resume(co)
+		while coroutine.status(co) == "suspended" do
+			events[#events + 1] = ev
+			ok, ev = coroutine.resume(co, ev .. "!")
+			assert(ok, ev)
+		end
+		assert(ev == "pcall! x x! 123", ev)
+		assert(table.concat(events, " "):find("^pcall error xpcall$"))`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Go functions go on in their continuations
+	co := ls.NewThread()
+	ls.Pop(1)
+	var k KFunction
+	k = func(ls LuaState, status ThreadStatus, ctx KContext) int {
+		ls.PushInteger(ctx.(int64) + ls.ToInteger(-1))
+		return 1
+	}
+	co.PushGoFunction(func(ls LuaState) int {
+		ls.PushInteger(1)
+		return ls.YieldK(1, int64(10), k)
+	})
+	assert.IntEqual(t, co.Resume(ls, 0), LUA_YIELD)
+	co.Pop(1)
+	co.PushInteger(5)
+	assert.IntEqual(t, co.Resume(ls, 1), LUA_OK)
+	assert.IntEqual(t, int(co.ToInteger(-1)), 15)
+
+	co = ls.NewThread()
+	ls.Pop(1)
+	co.PushGoFunction(func(ls LuaState) int {
+		ls.PushString("before")
+		ls.LoadString("coroutine.yield(); error('boom', 0)")
+		status := ls.PCallK(0, 0, 0, "ctx", func(ls LuaState, status ThreadStatus, ctx KContext) int {
+			s, _ := ls.ToString(-1)
+			below, _ := ls.ToString(-2)
+			ls.PushString(below + " " + s + " " + ctx.(string))
+			return 1
+		})
+		panic(status) // not reached: the call yields
+	})
+	assert.IntEqual(t, co.Resume(ls, 0), LUA_YIELD)
+	assert.IntEqual(t, co.Resume(ls, 0), LUA_OK)
+	s, _ := co.ToString(-1)
+	assert.StringEqual(t, s, "before boom ctx")
+}
+
+func TestCoroutineLib(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local main, ismain = coroutine.running()
+		assert(type(main) == "thread" and ismain)
+		assert(not coroutine.isyieldable())
+		assert(coroutine.status(main) == "running")
+
+		local co
+		co = coroutine.create(function()
+			assert(coroutine.status(co) == "running")
+			assert(select(2, coroutine.running()) == false)
+			assert(coroutine.isyieldable())
+			assert(coroutine.status(main) == "normal")
+			local inner = coroutine.create(function()
+				return coroutine.status(co)
+			end)
+			assert(select(2, coroutine.resume(inner)) == "normal")
+			string.gsub("a", ".", function() assert(not coroutine.isyieldable()) end)
+			coroutine.yield()
+		end)
+		assert(coroutine.status(co) == "suspended")
+		assert(coroutine.resume(co))
+		assert(coroutine.status(co) == "suspended")
+		assert(coroutine.resume(co))
+		assert(coroutine.status(co) == "dead")
+
+		local gen = coroutine.wrap(function(a)
+			local b = coroutine.yield(a + 1)
+			error("oops " .. b)
+		end)
+		assert(gen(1) == 2)
+		local ok, msg = pcall(gen, "x")
+		assert(not ok and msg:find("^%[string .-%]:%d+: oops x$"), msg)
+		ok, msg = pcall(gen)
+		assert(not ok and msg:find("cannot resume dead coroutine"), msg)`)
+	if err != nil {
+		t.Error(err)
+	}
+}
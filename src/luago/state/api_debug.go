@@ -3,83 +3,225 @@ package state
 import "strings"
 import . "luago/api"
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethook
 func (self *luaState) GetHook() LuaHook {
-	panic("todo: GetHook!")
+	return self.hook
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_sethook
+// lua-5.3.4/src/ldebug.c#lua_sethook()
 func (self *luaState) SetHook(f LuaHook, mask, count int) {
-	panic("todo: SetHook!")
+	if f == nil || mask == 0 { /* turn off hooks? */
+		mask = 0
+		f = nil
+	}
+	self.hook = f
+	self.baseHookCount = count
+	self.hookCount = count
+	self.hookMask = mask
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookcount
 func (self *luaState) GetHookCount() int {
-	panic("todo: GetHookCount!")
+	return self.baseHookCount
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookmask
 func (self *luaState) GetHookMask() int {
-	panic("todo: GetHookMask!")
+	return self.hookMask
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getstack
+// lua-5.3.4/src/ldebug.c#lua_getstack()
 func (self *luaState) GetStack(level int, ar *LuaDebug) bool {
-	//panic("todo: GetStack!")
-	// todo
-	if self.callDepth > 1 {
+	if level < 0 {
+		return false /* invalid (negative) level */
+	}
+	stack := self.stack
+	for ; level > 0 && stack.prev != nil; stack = stack.prev {
+		level--
+	}
+	if level == 0 && stack.prev != nil { /* level found? */
+		ar.CallInfo = stack
 		return true
 	}
-	return false
+	return false /* no such level */
 }
 
+// [-(0|1), +(0|1|2), e]
+// http://www.lua.org/manual/5.3/manual.html#lua_getinfo
+// lua-5.3.4/src/ldebug.c#lua_getinfo()
 func (self *luaState) GetInfo(what string, ar *LuaDebug) bool {
+	var stack *luaStack
+	var fn luaValue
 	if len(what) > 0 && what[0] == '>' {
-		val := self.stack.pop()
-		if _, ok := val.(*closure); ok {
-			if strings.IndexByte(what, 'n') >= 0 {
-				ar.Name = ""     // todo
-				ar.NameWhat = "" // todo
-			}
-			if strings.IndexByte(what, 'S') >= 0 {
-				ar.Source = ""         // todo
-				ar.ShortSrc = ""       // todo
-				ar.LineDefined = 0     // todo
-				ar.LastLineDefined = 0 // todo
-				ar.What = ""           // todo
-			}
-			if strings.IndexByte(what, 'l') >= 0 {
-				ar.CurrentLine = 0 // todo
-			}
-			if strings.IndexByte(what, 't') >= 0 {
-				ar.IsTailCall = false // todo
-			}
-			if strings.IndexByte(what, 'u') >= 0 {
-				ar.NUps = 0         // todo
-				ar.NParams = 0      // todo
-				ar.IsVararg = false // todo
+		if fn = self.stack.get(-1); fn.tt != LUA_TFUNCTION {
+			self.Error2("function expected")
+		}
+		self.stack.pop()
+		what = what[1:] /* skip the '>' */
+	} else {
+		stack = ar.CallInfo.(*luaStack)
+		fn = closureValue(stack.closure)
+	}
+
+	c := fn.asClosure()
+	status := true
+	for i := 0; i < len(what); i++ {
+		switch what[i] {
+		case 'S':
+			_funcInfo(ar, c)
+		case 'l':
+			ar.CurrentLine = -1
+			if stack != nil && c.proto != nil {
+				ar.CurrentLine = stack.currentLine()
 			}
-			if strings.IndexByte(what, 'f') >= 0 {
-				panic("todo")
+		case 'u':
+			ar.NUps = len(c.upvals)
+			if c.proto == nil {
+				ar.IsVararg = true
+				ar.NParams = 0
+			} else {
+				ar.IsVararg = c.proto.IsVararg == 1
+				ar.NParams = int(c.proto.NumParams)
 			}
-			if strings.IndexByte(what, 'L') >= 0 {
-				panic("todo")
+		case 't':
+			ar.IsTailCall = stack != nil && stack.tailCall
+		case 'n':
+			ar.NameWhat, ar.Name = "", ""
+			if stack != nil {
+				ar.NameWhat, ar.Name = stack.getFuncName()
 			}
+		case 'L', 'f': /* handled below */
+		default:
+			status = false /* invalid option */
+		}
+	}
+	if strings.IndexByte(what, 'f') >= 0 {
+		self.stack.push(fn)
+	}
+	if strings.IndexByte(what, 'L') >= 0 {
+		self.stack.push(_validLines(c))
+	}
+	return status
+}
+
+// lua-5.3.4/src/ldebug.c#funcinfo()
+func _funcInfo(ar *LuaDebug, c *closure) {
+	if c.proto == nil {
+		ar.Source = "=[C]"
+		ar.LineDefined = -1
+		ar.LastLineDefined = -1
+		ar.What = "C"
+	} else {
+		ar.Source = c.proto.Source
+		if ar.Source == "" {
+			ar.Source = "=?"
+		}
+		ar.LineDefined = int(c.proto.LineDefined)
+		ar.LastLineDefined = int(c.proto.LastLineDefined)
+		if ar.LineDefined == 0 {
+			ar.What = "main"
 		} else {
-			panic("function expected")
+			ar.What = "Lua"
 		}
 	}
-	panic("todo: GetInfo!" + what)
+	ar.ShortSrc = ChunkID(ar.Source)
 }
 
+// lua-5.3.4/src/ldebug.c#collectvalidlines()
+func _validLines(c *closure) luaValue {
+	if c.proto == nil {
+		return nilValue
+	}
+	t := newLuaTable(0, 0)
+	for _, line := range c.proto.LineInfo {
+		t.put(intValue(int64(line)), boolValue(true))
+	}
+	return tableValue(t)
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getlocal
+// lua-5.3.4/src/ldebug.c#lua_getlocal()
 func (self *luaState) GetLocal(ar *LuaDebug, n int) string {
-	panic("todo: GetLocal!")
+	if ar == nil { /* information about non-active function? */
+		c := self.stack.pop().asClosure()
+		if c == nil || c.proto == nil {
+			return ""
+		}
+		return _localName(c.proto, n, 0) /* only parameters */
+	}
+	name, val := ar.CallInfo.(*luaStack).findLocal(n)
+	if name != "" {
+		self.stack.push(*val)
+	}
+	return name
 }
 
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setlocal
+// lua-5.3.4/src/ldebug.c#lua_setlocal()
 func (self *luaState) SetLocal(ar *LuaDebug, n int) string {
-	panic("todo: SetLocal!")
+	name, val := ar.CallInfo.(*luaStack).findLocal(n)
+	if name != "" {
+		*val = self.stack.pop()
+	}
+	return name
+}
+
+// lua-5.3.4/src/ldebug.c#findlocal()
+func (self *luaStack) findLocal(n int) (string, *luaValue) {
+	name := ""
+	if c := self.closure; c.proto != nil {
+		if n < 0 { /* access to vararg values? */
+			if varargs := self.varargs(); -n <= len(varargs) {
+				return "(*vararg)", &varargs[-n-1]
+			}
+			return "", nil /* no such vararg */
+		}
+		name = _localName(c.proto, n, self.pc-1)
+	}
+	if name == "" { /* no 'standard' name? */
+		if n <= 0 || n > self.top { /* is 'n' inside 'ci' stack? */
+			return "", nil
+		}
+		if self.closure.proto != nil {
+			name = "(*temporary)"
+		} else {
+			name = "(C temporary)"
+		}
+	}
+	return name, &self.slots[n-1]
+}
+
+// Look for n-th local variable at line 'line' in function 'func'.
+// Returns "" if not found.
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname()
+func _localName(proto *funcProto, n, pc int) string {
+	for _, locVar := range proto.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { /* is variable active? */
+			if n--; n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return "" /* not found */
 }
 
 // [-0, +(0|1), –]
 // http://www.lua.org/manual/5.3/manual.html#lua_getupvalue
 func (self *luaState) GetUpvalue(funcIdx, n int) string {
 	val := self.stack.get(funcIdx)
-	if c, ok := val.(*closure); ok {
+	if c := val.asClosure(); c != nil {
 		if len(c.upvals) >= n {
 			uv := *(c.upvals[n-1].val)
 			self.stack.push(uv)
@@ -93,7 +235,7 @@ func (self *luaState) GetUpvalue(funcIdx, n int) string {
 // http://www.lua.org/manual/5.3/manual.html#lua_setupvalue
 func (self *luaState) SetUpvalue(funcIdx, n int) string {
 	val := self.stack.get(funcIdx)
-	if c, ok := val.(*closure); ok {
+	if c := val.asClosure(); c != nil {
 		if len(c.upvals) >= n {
 			*(c.upvals[n-1].val) = self.stack.pop()
 			return c.getUpvalueName(n - 1)
@@ -106,16 +248,19 @@ func (self *luaState) SetUpvalue(funcIdx, n int) string {
 // http://www.lua.org/manual/5.3/manual.html#lua_upvalueid
 func (self *luaState) UpvalueId(funcIdx, n int) interface{} {
 	val := self.stack.get(funcIdx)
-	if c, ok := val.(*closure); ok {
+	if c := val.asClosure(); c != nil {
 		if len(c.upvals) >= n {
-			return *(c.upvals[n-1])
+			return c.upvals[n-1]
 		}
 	}
-	return nil // todo
+	return nil
 }
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_upvaluejoin
+// lua-5.3.4/src/lapi.c#lua_upvaluejoin()
 func (self *luaState) UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int) {
-	panic("todo: UpvalueJoin!")
+	c1 := self.stack.get(funcIdx1).asClosure()
+	c2 := self.stack.get(funcIdx2).asClosure()
+	c1.upvals[n1-1] = c2.upvals[n2-1]
 }
@@ -0,0 +1,149 @@
+package state
+
+import "testing"
+import "assert"
+import . "luago/api"
+
+func TestGetInfo(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	var infos []LuaDebug
+	ls.Register("where", func(ls LuaState) int {
+		for level := 0; ; level++ {
+			ar := &LuaDebug{}
+			if !ls.GetStack(level, ar) {
+				break
+			}
+			ls.GetInfo("Slut", ar)
+			infos = append(infos, *ar)
+		}
+		return 0
+	})
+	ls.DoString("local function f(a, b, ...)\n  where()\nend\nf(1, 2)")
+
+	assert.IntEqual(t, len(infos), 3)
+	assert.StringEqual(t, infos[0].What, "C")
+	assert.StringEqual(t, infos[0].ShortSrc, "[C]")
+	assert.IntEqual(t, infos[0].CurrentLine, -1)
+	assert.StringEqual(t, infos[1].What, "Lua")
+	assert.IntEqual(t, infos[1].CurrentLine, 2)
+	assert.IntEqual(t, infos[1].LineDefined, 1)
+	assert.IntEqual(t, infos[1].LastLineDefined, 3)
+	assert.IntEqual(t, infos[1].NParams, 2)
+	assert.IntEqual(t, infos[1].NUps, 1)
+	if !infos[1].IsVararg || infos[1].IsTailCall {
+		t.Errorf("unexpected info: %v", infos[1])
+	}
+	assert.StringEqual(t, infos[2].What, "main")
+	assert.IntEqual(t, infos[2].CurrentLine, 4)
+
+	ls.GetGlobal("print")
+	ar := &LuaDebug{}
+	ls.GetInfo(">Sf", ar)
+	assert.StringEqual(t, ar.What, "C")
+	if !ls.IsGoFunction(-1) {
+		t.Error("function expected")
+	}
+	ls.Pop(1)
+	assert.IntEqual(t, ls.GetTop(), 0)
+}
+
+func TestGetInfoName(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local function name()
+			local ar = debug.getinfo(2, "n")
+			return ar.namewhat .. " " .. tostring(ar.name)
+		end
+		local function f() return (name()) end
+		local t = {g = f}
+		function h() return (name()) end
+		assert(f() == "local f")
+		assert(t.g() == "field g")
+		assert(t:g() == "method g")
+		assert(h() == "global h")
+		assert(select(2, pcall(f)) == " nil")`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetInfoOptions(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	ls.Register("info", func(ls LuaState) int {
+		ls.GetInfo(">S", &LuaDebug{})
+		return 0
+	})
+	err := ls.DoStringErr(`
+		for _, opt in ipairs({"X", ">S", "S>"}) do
+			local ok, msg = pcall(debug.getinfo, 1, opt)
+			assert(not ok and msg:find("invalid option"), opt)
+			ok, msg = pcall(debug.getinfo, print, opt)
+			assert(not ok and msg:find("invalid option"), opt)
+		end
+		local ok, msg = pcall(info, 1)
+		assert(not ok and msg:find("function expected"))`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetLocal(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	ls.DoString(`
+		local function f(a, b, ...)
+			local c = a + b
+			local names = {}
+			names[1] = debug.getlocal(1, 1)
+			names[2] = debug.getlocal(1, 3)
+			names[3] = debug.getlocal(1, 4)
+			names[4] = select(2, debug.getlocal(1, -2))
+			names[5] = tostring(debug.getlocal(1, -3))
+			names[6] = tostring(debug.getlocal(2, 1))
+			debug.setlocal(1, 3, 100)
+			return table.concat(names, " "), c
+		end
+		s, c = f(1, 2, "x", "y")
+		p = debug.getlocal(f, 2)
+		q = debug.getlocal(f, 3)`)
+
+	ls.GetGlobal("s")
+	s, _ := ls.ToString(-1)
+	assert.StringEqual(t, s, "a c names y nil f")
+	ls.GetGlobal("c")
+	assert.IntEqual(t, int(ls.ToInteger(-1)), 100)
+	ls.GetGlobal("p")
+	s, _ = ls.ToString(-1)
+	assert.StringEqual(t, s, "b")
+	ls.GetGlobal("q")
+	if !ls.IsNil(-1) {
+		t.Error("nil expected")
+	}
+}
+
+func TestUpvalueJoin(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local a, b = 1, 2
+		local function f() return a end
+		local function g() return b end
+		assert(debug.upvalueid(f, 1) ~= debug.upvalueid(g, 1))
+		debug.upvaluejoin(f, 1, g, 1)
+		assert(debug.upvalueid(f, 1) == debug.upvalueid(g, 1))
+		assert(f() == 2)
+		assert(not pcall(debug.upvalueid, f, 2))
+		local ok, msg = pcall(debug.upvaluejoin, print, 1, g, 1)
+		assert(not ok and msg:find("Lua function expected"))`)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -12,7 +12,15 @@ func (self *luaState) NewTable() {
 // http://www.lua.org/manual/5.3/manual.html#lua_createtable
 func (self *luaState) CreateTable(nArr, nRec int) {
 	t := newLuaTable(nArr, nRec)
-	self.stack.push(t)
+	self.stack.push(tableValue(t))
+	self.checkGC()
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newuserdata
+func (self *luaState) NewUserData(v interface{}) {
+	self.stack.push(userDataValue(newUserData(v)))
+	self.checkGC()
 }
 
 // [-1, +1, e]
@@ -27,14 +35,14 @@ func (self *luaState) GetTable(idx int) LuaType {
 // http://www.lua.org/manual/5.3/manual.html#lua_getfield
 func (self *luaState) GetField(idx int, k string) LuaType {
 	t := self.stack.get(idx)
-	return self.getTable(t, k, false)
+	return self.getTable(t, stringValue(k), false)
 }
 
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_geti
 func (self *luaState) GetI(idx int, i int64) LuaType {
 	t := self.stack.get(idx)
-	return self.getTable(t, i, false)
+	return self.getTable(t, intValue(i), false)
 }
 
 // [-1, +1, –]
@@ -49,21 +57,21 @@ func (self *luaState) RawGet(idx int) LuaType {
 // http://www.lua.org/manual/5.3/manual.html#lua_rawgeti
 func (self *luaState) RawGetI(idx int, i int64) LuaType {
 	t := self.stack.get(idx)
-	return self.getTable(t, i, true)
+	return self.getTable(t, intValue(i), true)
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_rawgetp
 func (self *luaState) RawGetP(idx int, p UserData) LuaType {
 	t := self.stack.get(idx)
-	return self.getTable(t, p, true)
+	return self.getTable(t, self.checkLightUserData(p), true)
 }
 
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_getglobal
 func (self *luaState) GetGlobal(name string) LuaType {
-	t := self.registry.get(LUA_RIDX_GLOBALS)
-	return self.getTable(t, name, false)
+	t := self.registry.getInt(LUA_RIDX_GLOBALS)
+	return self.getTable(t, stringValue(name), false)
 }
 
 // [-0, +(0|1), –]
@@ -72,7 +80,7 @@ func (self *luaState) GetMetatable(idx int) bool {
 	val := self.stack.get(idx)
 
 	if mt := getMetatable(val, self); mt != nil {
-		self.stack.push(mt)
+		self.stack.push(tableValue(mt))
 		return true
 	} else {
 		return false
@@ -82,25 +90,30 @@ func (self *luaState) GetMetatable(idx int) bool {
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_getuservalue
 func (self *luaState) GetUserValue(idx int) LuaType {
-	panic("todo!")
+	val := self.stack.get(idx)
+	if ud := val.asUserData(); ud != nil {
+		self.stack.push(ud.userValue)
+		return typeOf(ud.userValue)
+	}
+	panic("full userdata expected!")
 }
 
 // push(t[k])
 func (self *luaState) getTable(t, k luaValue, raw bool) LuaType {
-	if tbl, ok := t.(*luaTable); ok {
+	if tbl := t.asTable(); tbl != nil {
 		v := tbl.get(k)
-		if raw || v != nil || !tbl.hasMetafield("__index") {
+		if raw || !v.isNil() || !tbl.hasMetafield("__index") {
 			self.stack.push(v)
 			return typeOf(v)
 		}
 	}
 
 	if !raw {
-		if mf := getMetafield(t, "__index", self); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return self.getTable(x, k, true)
-			case *closure:
+		if mf := getMetafield(t, "__index", self); !mf.isNil() {
+			switch mf.tt {
+			case LUA_TTABLE:
+				return self.getTable(mf, k, true)
+			case LUA_TFUNCTION:
 				self.stack.push(mf)
 				self.stack.push(t)
 				self.stack.push(k)
@@ -111,5 +124,6 @@ func (self *luaState) getTable(t, k luaValue, raw bool) LuaType {
 		}
 	}
 
-	panic("not a table!") // todo
+	self.objTypeError(t, "index")
+	return LUA_TNONE
 }
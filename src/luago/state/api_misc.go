@@ -1,6 +1,5 @@
 package state
 
-import "runtime"
 import "luago/number"
 import . "luago/api"
 
@@ -8,7 +7,11 @@ import . "luago/api"
 // http://www.lua.org/manual/5.3/manual.html#lua_close
 // lua-5.3.4/src/lstate.c#lua_close
 func (self *luaState) Close() {
-	// todo
+	if self.gc.closed {
+		return
+	}
+	/* only the main thread can be closed */
+	self.registry.getInt(LUA_RIDX_MAINTHREAD).asThread().closeState()
 }
 
 // [-0, +0, –]
@@ -30,15 +33,44 @@ func (self *luaState) Version() float64 {
 // [-1, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#lua_error
 func (self *luaState) Error() int {
-	err := newLuaTable(0, 1)
-	err.put("_ERR", self.stack.pop())
-	panic(err)
+	panic(&LuaError{Status: LUA_ERRRUN, Value: self.stack.pop().toInterface()})
 }
 
 // [-0, +0, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_gc
 func (self *luaState) GC(what, data int) int {
-	runtime.GC()
+	self.checkOpen()
+	g := self.gc
+	switch what {
+	case LUA_GCSTOP:
+		g.running = false
+	case LUA_GCRESTART:
+		g.running = true
+	case LUA_GCCOLLECT:
+		self.fullGC()
+	case LUA_GCCOUNT:
+		/* GC values are expressed in Kbytes: #bytes/2^10 */
+		return int(_heapSize() >> 10)
+	case LUA_GCCOUNTB:
+		return int(_heapSize() & 0x3ff)
+	case LUA_GCSTEP:
+		self.fullGC()
+		return 1 /* a step always ends a cycle */
+	case LUA_GCSETPAUSE:
+		old := g.pause
+		g.pause = data
+		return old
+	case LUA_GCSETSTEPMUL:
+		old := g.stepmul
+		g.stepmul = data
+		return old
+	case LUA_GCISRUNNING:
+		if g.running {
+			return 1
+		}
+	default:
+		return -1 /* invalid option */
+	}
 	return 0
 }
 
@@ -60,9 +92,9 @@ func (self *luaState) StringToNumber(s string) bool {
 // http://www.lua.org/manual/5.3/manual.html#lua_next
 func (self *luaState) Next(idx int) bool {
 	val := self.stack.get(idx)
-	if t, ok := val.(*luaTable); ok {
+	if t := val.asTable(); t != nil {
 		key := self.stack.pop()
-		if nextKey, nextVal := t.next(key); nextKey != nil {
+		if nextKey, nextVal := t.next(key); !nextKey.isNil() {
 			self.stack.push(nextKey)
 			self.stack.push(nextVal)
 			return true
@@ -77,15 +109,14 @@ func (self *luaState) Next(idx int) bool {
 // http://www.lua.org/manual/5.3/manual.html#lua_len
 func (self *luaState) Len(idx int) {
 	val := self.stack.get(idx)
-	if s, ok := val.(string); ok {
-		self.stack.push(int64(len(s)))
+	if val.tt == LUA_TSTRING {
+		self.stack.push(intValue(int64(len(val.asString()))))
 	} else if result, ok := callMetamethod(val, val, "__len", self); ok {
 		self.stack.push(result)
-	} else if t, ok := val.(*luaTable); ok {
-		self.stack.push(int64(t.len()))
+	} else if t := val.asTable(); t != nil {
+		self.stack.push(intValue(int64(t.len())))
 	} else {
-		typeName := self.TypeName(typeOf(val))
-		panic("attempt to get length of a " + typeName + " value")
+		self.objTypeError(val, "get length of")
 	}
 }
 
@@ -93,14 +124,15 @@ func (self *luaState) Len(idx int) {
 // http://www.lua.org/manual/5.3/manual.html#lua_concat
 func (self *luaState) Concat(n int) {
 	if n == 0 {
-		self.stack.push("")
+		self.stack.push(stringValue(""))
 	} else if n >= 2 {
 		for i := 1; i < n; i++ {
 			if s2, ok := self.ToString(-1); ok {
 				if s1, ok := self.ToString(-2); ok {
+					self.CheckStringLength(len(s1) + len(s2))
 					self.stack.pop()
 					self.stack.pop()
-					self.stack.push(s1 + s2)
+					self.stack.push(stringValue(s1 + s2))
 					continue
 				}
 			}
@@ -112,14 +144,9 @@ func (self *luaState) Concat(n int) {
 				continue
 			}
 
-			var typeName string
-			if _, ok := convertToFloat(a); !ok {
-				typeName = self.TypeName(typeOf(a))
-			} else {
-				typeName = self.TypeName(typeOf(b))
-			}
-			panic("attempt to concatenate a " + typeName + " value")
+			self.concatError(a, b)
 		}
 	}
 	// n == 1, do nothing
+	self.checkGC()
 }
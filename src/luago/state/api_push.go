@@ -6,44 +6,44 @@ import . "luago/api"
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushnil
 func (self *luaState) PushNil() {
-	self.stack.push(nil)
+	self.stack.push(nilValue)
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushboolean
 func (self *luaState) PushBoolean(b bool) {
-	self.stack.push(b)
+	self.stack.push(boolValue(b))
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushinteger
 func (self *luaState) PushInteger(n int64) {
-	self.stack.push(n)
+	self.stack.push(intValue(n))
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushnumber
 func (self *luaState) PushNumber(n float64) {
-	self.stack.push(n)
+	self.stack.push(floatValue(n))
 }
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushstring
 func (self *luaState) PushString(s string) {
-	self.stack.push(s)
+	self.stack.push(stringValue(s))
 }
 
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushfstring
 func (self *luaState) PushFString(fmtStr string, a ...interface{}) {
 	str := fmt.Sprintf(fmtStr, a...)
-	self.stack.push(str)
+	self.stack.push(stringValue(str))
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushcfunction
 func (self *luaState) PushGoFunction(f GoFunction) {
-	self.stack.push(newGoClosure(f, 0))
+	self.stack.push(closureValue(newGoClosure(f, 0)))
 }
 
 // [-n, +1, m]
@@ -52,28 +52,27 @@ func (self *luaState) PushGoClosure(f GoFunction, n int) {
 	closure := newGoClosure(f, n)
 	for i := n; i > 0; i-- {
 		val := self.stack.pop()
-		closure.upvals[n-1] = &upvalue{&val}
+		closure.upvals[i-1] = &upvalue{&val}
 	}
-	self.stack.push(closure)
+	self.stack.push(closureValue(closure))
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushthread
 func (self *luaState) PushThread() bool {
-	self.stack.push(self)
+	self.stack.push(threadValue(self))
 	return self.isMainThread()
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushlightuserdata
-func (self *luaState) PushUserData(d UserData) {
-	ud := &userData{data: d}
-	self.stack.push(ud)
+func (self *luaState) PushLightUserData(p UserData) {
+	self.stack.push(self.checkLightUserData(p))
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushglobaltable
 func (self *luaState) PushGlobalTable() {
-	global := self.registry.get(LUA_RIDX_GLOBALS)
+	global := self.registry.getInt(LUA_RIDX_GLOBALS)
 	self.stack.push(global)
 }
@@ -0,0 +1,37 @@
+package state
+
+import "fmt"
+import . "luago/api"
+
+// panic value of quota errors, they are
+// only caught by the outermost PCall
+type quotaError struct {
+	msg string
+}
+
+// [-0, +0, –]
+func (self *luaState) SetLimits(limits Limits) {
+	self.limits = limits
+}
+
+// [-0, +0, –]
+func (self *luaState) Limits() Limits {
+	return self.limits
+}
+
+// [-0, +0, v]
+func (self *luaState) CheckStringLength(size int) {
+	if max := self.limits.MaxStringLen; max > 0 && size > max {
+		self.quotaError("string length limit exceeded")
+	}
+}
+
+func (self *luaState) checkStackSize(size int) {
+	if max := self.limits.MaxStackSize; max > 0 && size > max {
+		self.quotaError("stack size limit exceeded")
+	}
+}
+
+func (self *luaState) quotaError(format string, a ...interface{}) {
+	panic(&quotaError{fmt.Sprintf(format, a...)})
+}
@@ -0,0 +1,51 @@
+package state
+
+import "testing"
+import "assert"
+import . "luago/api"
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits Limits
+		chunk  string
+		msg    string
+	}{
+		{Limits{MaxInstructions: 1000}, `while true do end`, "instruction limit exceeded"},
+		{Limits{MaxCallDepth: 50}, `local function f() return 1 + f() end f()`, "call depth limit exceeded"},
+		{Limits{MaxStackSize: 100}, `print(table.unpack({}, 1, 200))`, "stack size limit exceeded"},
+		{Limits{MaxStringLen: 100}, `local s = "" for i = 1, 101 do s = s .. "x" end`, "string length limit exceeded"},
+		{Limits{MaxStringLen: 100}, `string.rep("x", 101)`, "string length limit exceeded"},
+		{Limits{MaxStringLen: 100}, `table.concat({string.rep("x", 50), "y"}, string.rep("-", 50))`, "string length limit exceeded"},
+		{Limits{MaxInstructions: 1000}, `coroutine.resume(coroutine.create(function() while true do end end))`, "instruction limit exceeded"},
+	}
+
+	for _, test := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.SetLimits(test.limits)
+		// quota errors can't be caught by scripts
+		ls.LoadString(`pcall(function() ` + test.chunk + ` end) return "ok"`)
+		assert.IntEqual(t, ls.PCall(0, 1, 0), LUA_ERRQUOTA)
+		s, _ := ls.ToString(-1)
+		assert.StringEqual(t, s, test.msg)
+	}
+
+	ls := New()
+	ls.OpenLibs()
+	ls.SetLimits(Limits{MaxInstructions: 1000, MaxStringLen: 100})
+	ls.LoadString(`local s = "" for i = 1, 100 do s = s .. "x" end return s`)
+	assert.IntEqual(t, ls.PCall(0, 1, 0), LUA_OK)
+	ls.LoadString(`for i = 1, 100 do end`)
+	assert.IntEqual(t, ls.PCall(0, 0, 0), LUA_OK) // counted per PCall
+
+	// oversized results are errors that scripts can catch
+	ls = New()
+	ls.OpenLibs()
+	for _, n := range []string{"1 << 31", "1 << 40", "math.maxinteger"} {
+		ls.LoadString(`return pcall(string.rep, "xx", ` + n + `)`)
+		ls.Call(0, 2)
+		s, _ := ls.ToString(-1)
+		assert.StringEqual(t, s, "resulting string too large")
+		ls.SetTop(0)
+	}
+}
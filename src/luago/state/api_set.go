@@ -16,7 +16,7 @@ func (self *luaState) SetTable(idx int) {
 func (self *luaState) SetField(idx int, k string) {
 	t := self.stack.get(idx)
 	v := self.stack.pop()
-	self.setTable(t, k, v, false)
+	self.setTable(t, stringValue(k), v, false)
 }
 
 // [-1, +0, e]
@@ -24,7 +24,7 @@ func (self *luaState) SetField(idx int, k string) {
 func (self *luaState) SetI(idx int, i int64) {
 	t := self.stack.get(idx)
 	v := self.stack.pop()
-	self.setTable(t, i, v, false)
+	self.setTable(t, intValue(i), v, false)
 }
 
 // [-2, +0, m]
@@ -41,7 +41,7 @@ func (self *luaState) RawSet(idx int) {
 func (self *luaState) RawSetI(idx int, i int64) {
 	t := self.stack.get(idx)
 	v := self.stack.pop()
-	self.setTable(t, i, v, true)
+	self.setTable(t, intValue(i), v, true)
 }
 
 // [-1, +0, m]
@@ -49,7 +49,7 @@ func (self *luaState) RawSetI(idx int, i int64) {
 func (self *luaState) RawSetP(idx int, p UserData) {
 	t := self.stack.get(idx)
 	v := self.stack.pop()
-	self.setTable(t, p, v, true)
+	self.setTable(t, self.checkLightUserData(p), v, true)
 }
 
 // [-0, +0, e]
@@ -62,9 +62,9 @@ func (self *luaState) Register(name string, f GoFunction) {
 // [-1, +0, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_setglobal
 func (self *luaState) SetGlobal(name string) {
-	t := self.registry.get(LUA_RIDX_GLOBALS)
+	t := self.registry.getInt(LUA_RIDX_GLOBALS)
 	v := self.stack.pop()
-	self.setTable(t, name, v, false)
+	self.setTable(t, stringValue(name), v, false)
 }
 
 // [-1, +0, –]
@@ -73,9 +73,9 @@ func (self *luaState) SetMetatable(idx int) {
 	val := self.stack.get(idx)
 	mtVal := self.stack.pop()
 
-	if mtVal == nil {
+	if mtVal.isNil() {
 		setMetatable(val, nil, self)
-	} else if mt, ok := mtVal.(*luaTable); ok {
+	} else if mt := mtVal.asTable(); mt != nil {
 		setMetatable(val, mt, self)
 	} else {
 		panic("table expected!") // todo
@@ -85,27 +85,29 @@ func (self *luaState) SetMetatable(idx int) {
 // [-1, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_setuservalue
 func (self *luaState) SetUserValue(idx int) {
-	// val := self.stack.pop()
-	// ud := self.stack.get(idx)
-	panic("todo!")
+	if ud := self.stack.get(idx).asUserData(); ud != nil {
+		ud.userValue = self.stack.pop()
+		return
+	}
+	panic("full userdata expected!")
 }
 
 // t[k]=v
 func (self *luaState) setTable(t, k, v luaValue, raw bool) {
-	if tbl, ok := t.(*luaTable); ok {
-		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+	if tbl := t.asTable(); tbl != nil {
+		if raw || !tbl.get(k).isNil() || !tbl.hasMetafield("__newindex") {
 			tbl.put(k, v)
 			return
 		}
 	}
 
 	if !raw {
-		if mf := getMetafield(t, "__newindex", self); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				self.setTable(x, k, v, true)
+		if mf := getMetafield(t, "__newindex", self); !mf.isNil() {
+			switch mf.tt {
+			case LUA_TTABLE:
+				self.setTable(mf, k, v, true)
 				return
-			case *closure:
+			case LUA_TFUNCTION:
 				self.stack.push(mf)
 				self.stack.push(t)
 				self.stack.push(k)
@@ -116,5 +118,5 @@ func (self *luaState) setTable(t, k, v luaValue, raw bool) {
 		}
 	}
 
-	panic("not a table!") // todo
+	self.objTypeError(t, "index")
 }
@@ -20,8 +20,13 @@ func (self *luaState) AbsIndex(idx int) int {
 // http://www.lua.org/manual/5.3/manual.html#lua_checkstack
 // lua-5.3.4/src/lapi.c#lua_checkstack()
 func (self *luaState) CheckStack(n int) bool {
-	self.stack.check(n)
-	return true // never fails
+	stack := self.stack
+	if stack.top+n > stack.size &&
+		stack.base+stack.top+n > LUAI_MAXSTACK { /* would overflow? */
+		return false
+	}
+	stack.check(n)
+	return true
 }
 
 // [-n, +0, –]
@@ -105,7 +110,7 @@ func (self *luaState) SetTop(idx int) {
 		}
 	} else if n < 0 {
 		for i := 0; i > n; i-- {
-			self.stack.push(nil)
+			self.stack.push(nilValue)
 		}
 	}
 }
@@ -15,7 +15,7 @@ func (self *luaState) RegisterCount() int {
 }
 
 func (self *luaState) GetConst(idx int) {
-	c := self.stack.closure.proto.Constants[idx]
+	c := self.stack.closure.proto.consts[idx]
 	self.stack.push(c)
 }
 
@@ -29,38 +29,30 @@ func (self *luaState) GetRK(rk int) {
 
 func (self *luaState) LoadProto(idx int) {
 	stack := self.stack
-	subProto := stack.closure.proto.Protos[idx]
+	subProto := stack.closure.proto.protos[idx]
 	closure := newLuaClosure(subProto)
 
 	for i, uvInfo := range subProto.Upvalues {
 		if uvInfo.Instack == 1 {
-			closure.upvals[i] = &upvalue{&stack.slots[uvInfo.Idx]}
-			if stack.openuvs == nil {
-				stack.openuvs = map[int]*upvalue{}
-			}
-			stack.openuvs[int(uvInfo.Idx)] = closure.upvals[i]
+			closure.upvals[i] = stack.findUpvalue(int(uvInfo.Idx))
 		} else {
 			closure.upvals[i] = stack.closure.upvals[uvInfo.Idx]
 		}
 	}
 
-	stack.push(closure)
+	stack.push(closureValue(closure))
+	self.checkGC()
 }
 
 func (self *luaState) CloseUpvalues(a int) {
-	for i, openuv := range self.stack.openuvs {
-		if i >= a-1 {
-			val := *openuv.val
-			openuv.val = &val
-		}
-	}
+	self.stack.closeUpvalues(a - 1)
 }
 
 func (self *luaState) LoadVararg(n int) {
 	if n < 0 {
-		n = len(self.stack.varargs)
+		n = self.stack.nVarargs
 	}
 
 	self.stack.check(n)
-	self.stack.pushN(self.stack.varargs, n)
+	self.stack.pushN(self.stack.varargs(), n)
 }
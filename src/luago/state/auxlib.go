@@ -2,28 +2,175 @@ package state
 
 import "fmt"
 import "io/ioutil"
+import "strings"
 import . "luago/api"
 import "luago/stdlib"
 
 // [-0, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#luaL_error
+// lua-5.3.4/src/lauxlib.c#luaL_error()
 func (self *luaState) Error2(fmt string, a ...interface{}) int {
-	self.PushFString(fmt, a...) // todo
+	self.Where(1)
+	self.PushFString(fmt, a...)
+	self.Concat(2)
 	return self.Error()
 }
 
 // [-0, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#luaL_argerror
+// lua-5.3.4/src/lauxlib.c#luaL_argerror()
 func (self *luaState) ArgError(arg int, extraMsg string) int {
-	// bad argument #arg to 'funcname' (extramsg)
-	return self.Error2("bad argument #%d (%s)", arg, extraMsg) // todo
+	ar := &LuaDebug{}
+	if !self.GetStack(0, ar) { /* no stack frame? */
+		return self.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	self.GetInfo("n", ar)
+	if ar.NameWhat == "method" {
+		arg--         /* do not count 'self' */
+		if arg == 0 { /* error is in the self argument itself? */
+			return self.Error2("calling '%s' on bad self (%s)", ar.Name, extraMsg)
+		}
+	}
+	if ar.NameWhat == "" {
+		if ar.Name = self.globalFuncName(closureValue(self.stack.closure)); ar.Name == "" {
+			ar.Name = "?"
+		}
+	}
+	return self.Error2("bad argument #%d to '%s' (%s)", arg, ar.Name, extraMsg)
 }
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#luaL_where
+// lua-5.3.4/src/lauxlib.c#luaL_where()
 func (self *luaState) Where(lvl int) {
-	// chunkname:currentline:
-	panic("todo: Where!")
+	ar := &LuaDebug{}
+	if self.GetStack(lvl, ar) { /* check function at level */
+		self.GetInfo("Sl", ar)  /* get info about it */
+		if ar.CurrentLine > 0 { /* is there info? */
+			self.PushFString("%s:%d: ", ar.ShortSrc, ar.CurrentLine)
+			return
+		}
+	}
+	self.PushString("") /* else, no information available... */
+}
+
+const LEVELS1 = 10 /* size of the first part of the stack */
+const LEVELS2 = 11 /* size of the second part of the stack */
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_traceback
+// lua-5.3.4/src/lauxlib.c#luaL_traceback()
+func (self *luaState) Traceback(ls1 LuaState, msg string, level int) {
+	tb := _formatTraceback(ls1.StackFrames(level))
+	if msg != "" {
+		tb = msg + "\n" + tb
+	}
+	self.PushString(tb)
+}
+
+// [-0, +0, –]
+// Returns the activation records of the call stack, starting at level,
+// as they are described by Traceback.
+func (self *luaState) StackFrames(level int) []StackFrame {
+	var frames []StackFrame
+	ar := &LuaDebug{}
+	if !self.GetStack(level, ar) {
+		return nil
+	}
+	globalNames := map[*closure]string{} /* deep recursions repeat functions */
+	/* walk the frames directly: GetStack is linear in the level */
+	for stack := ar.CallInfo.(*luaStack); stack.prev != nil; stack = stack.prev {
+		ar.CallInfo = stack
+		self.GetInfo("Slnt", ar)
+		c := stack.closure
+		globalName, ok := globalNames[c]
+		if !ok {
+			globalName = self.globalFuncName(closureValue(c))
+			globalNames[c] = globalName
+		}
+		frame := StackFrame{
+			Source:     ar.ShortSrc,
+			Line:       ar.CurrentLine,
+			Function:   _funcName(ar, globalName),
+			IsTailCall: ar.IsTailCall,
+		}
+		if c.proto != nil {
+			frame.LineDefined = ar.LineDefined
+		} else {
+			frame.GoFunction = goFuncToString(c.goFunc)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func _formatTraceback(frames []StackFrame) string {
+	buf := "stack traceback:"
+	for i, n := 0, len(frames); i < n; i++ {
+		if i == LEVELS1 && n > LEVELS1+LEVELS2 { /* too many levels? */
+			buf += "\n\t..." /* add a '...' */
+			i = n - LEVELS2  /* and skip to last ones */
+		}
+		frame := frames[i]
+		buf += "\n\t" + frame.Source + ":"
+		if frame.Line > 0 {
+			buf += fmt.Sprintf("%d:", frame.Line)
+		}
+		buf += " in " + frame.Function
+		if frame.IsTailCall {
+			buf += "\n\t(...tail calls...)"
+		}
+	}
+	return buf
+}
+
+// lua-5.3.4/src/lauxlib.c#pushfuncname()
+func _funcName(ar *LuaDebug, globalName string) string {
+	if globalName != "" {
+		return "function '" + globalName + "'" /* try first a global name */
+	} else if ar.NameWhat != "" { /* is there a name from code? */
+		return ar.NameWhat + " '" + ar.Name + "'" /* use it */
+	} else if ar.What == "main" {
+		return "main chunk"
+	} else if ar.What != "C" { /* for Lua functions, use <file:line> */
+		return fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	} else { /* nothing left... */
+		return "?"
+	}
+}
+
+/*
+** Search for a name for a function in all loaded modules
+ */
+// lua-5.3.4/src/lauxlib.c#pushglobalfuncname()
+func (self *luaState) globalFuncName(fn luaValue) string {
+	if loaded := self.registry.getStr("_LOADED").asTable(); loaded != nil {
+		name := _findField(loaded, fn, 2)
+		return strings.TrimPrefix(name, "_G.") /* name start with '_G.'? */
+	}
+	return ""
+}
+
+/*
+** search for 'obj' in table t, up to the given level
+ */
+// lua-5.3.4/src/lauxlib.c#findfield()
+func _findField(t *luaTable, obj luaValue, level int) (name string) {
+	if level == 0 {
+		return "" /* not found */
+	}
+	t.forEach(func(k, v luaValue) {
+		if key := k.asString(); k.tt == LUA_TSTRING && name == "" { /* ignore non-string keys */
+			if v == obj { /* found object? */
+				name = key
+			} else if tbl := v.asTable(); tbl != nil {
+				if n := _findField(tbl, obj, level-1); n != "" { /* try recursively */
+					name = key + "." + n
+				}
+			}
+		}
+	})
+	return
 }
 
 // [-0, +0, v]
@@ -130,6 +277,19 @@ func (self *luaState) OptString(arg int, def string) string {
 	return self.CheckString(arg)
 }
 
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkoption
+// lua-5.3.4/src/lauxlib.c#luaL_checkoption()
+func (self *luaState) CheckOption(arg int, def string, lst []string) int {
+	name := self.OptString(arg, def)
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return self.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
 // [-0, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_dofile
 // lua-5.3.4/src/lauxlib.h#luaL_dofile()
@@ -146,6 +306,28 @@ func (self *luaState) DoString(str string) bool {
 		self.PCall(0, LUA_MULTRET, 0) == LUA_OK
 }
 
+// [-0, +?, –]
+// Like DoFile, but returns the error (a *LuaError) instead of pushing it.
+func (self *luaState) DoFileErr(filename string) error {
+	if status := self.LoadFile(filename); status != LUA_OK {
+		return self.loadError(status)
+	}
+	return self.PCallErr(0, LUA_MULTRET, 0)
+}
+
+// [-0, +?, –]
+// Like DoString, but returns the error (a *LuaError) instead of pushing it.
+func (self *luaState) DoStringErr(str string) error {
+	if status := self.LoadString(str); status != LUA_OK {
+		return self.loadError(status)
+	}
+	return self.PCallErr(0, LUA_MULTRET, 0)
+}
+
+func (self *luaState) loadError(status ThreadStatus) *LuaError {
+	return &LuaError{Status: status, Value: self.stack.pop().toInterface(), Line: -1}
+}
+
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#luaL_loadfile
 // lua-5.3.4/src/lauxlib.h#luaL_loadfile()
@@ -157,8 +339,9 @@ func (self *luaState) LoadFile(filename string) ThreadStatus {
 // http://www.lua.org/manual/5.3/manual.html#luaL_loadfilex
 func (self *luaState) LoadFileX(filename, mode string) ThreadStatus {
 	if data, err := ioutil.ReadFile(filename); err == nil {
-		return self.Load(data, filename, mode)
+		return self.Load(data, "@"+filename, mode)
 	}
+	self.PushFString("cannot open %s", filename)
 	return LUA_ERRFILE
 }
 
@@ -360,6 +543,113 @@ func (self *luaState) SetFuncs(l FuncReg, nup int) {
 	self.Pop(nup) /* remove upvalues */
 }
 
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_newmetatable
+// lua-5.3.4/src/lauxlib.c#luaL_newmetatable()
+func (self *luaState) NewMetatable(tname string) bool {
+	if self.GetMetatable2(tname) != LUA_TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	self.Pop(1)
+	self.CreateTable(0, 2) /* create metatable */
+	self.PushString(tname)
+	self.SetField(-2, "__name") /* metatable.__name = tname */
+	self.PushValue(-1)
+	self.SetField(LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_getmetatable
+// lua-5.3.4/src/lauxlib.h#luaL_getmetatable()
+func (self *luaState) GetMetatable2(tname string) LuaType {
+	return self.GetField(LUA_REGISTRYINDEX, tname)
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_setmetatable
+// lua-5.3.4/src/lauxlib.c#luaL_setmetatable()
+func (self *luaState) SetMetatable2(tname string) {
+	self.GetMetatable2(tname)
+	self.SetMetatable(-2)
+}
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_testudata
+// lua-5.3.4/src/lauxlib.c#luaL_testudata()
+func (self *luaState) TestUData(arg int, tname string) UserData {
+	if ud := self.testUData(arg, tname); ud != nil {
+		return ud.data
+	}
+	return nil
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkudata
+// lua-5.3.4/src/lauxlib.c#luaL_checkudata()
+func (self *luaState) CheckUData(arg int, tname string) UserData {
+	ud := self.testUData(arg, tname)
+	if ud == nil {
+		self.typeError(arg, tname)
+	}
+	return ud.data
+}
+
+func (self *luaState) testUData(arg int, tname string) *userData {
+	ud := self.stack.get(arg).asUserData()
+	if ud == nil { /* value is not a full userdata? */
+		return nil
+	}
+	if !self.GetMetatable(arg) { /* does it have a metatable? */
+		return nil
+	}
+	self.GetMetatable2(tname) /* get correct metatable */
+	sameMT := self.RawEqual(-1, -2)
+	self.Pop(2)  /* remove both metatables */
+	if !sameMT { /* not the same? */
+		return nil /* value is a userdata with wrong metatable */
+	}
+	return ud
+}
+
+/* index of free-list header */
+const freelist = 0
+
+// [-1, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_ref
+// lua-5.3.4/src/lauxlib.c#luaL_ref()
+func (self *luaState) Ref(t int) int {
+	if self.IsNil(-1) {
+		self.Pop(1)       /* remove it from stack */
+		return LUA_REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = self.AbsIndex(t)
+	self.RawGetI(t, freelist)      /* get first free element */
+	ref := int(self.ToInteger(-1)) /* ref = t[freelist] */
+	self.Pop(1)                    /* remove it from stack */
+	if ref != 0 {                  /* any free element? */
+		self.RawGetI(t, int64(ref)) /* remove it from list */
+		self.RawSetI(t, freelist)   /* (t[freelist] = t[ref]) */
+	} else { /* no free elements */
+		ref = int(self.RawLen(t)) + 1 /* get a new reference */
+	}
+	self.RawSetI(t, int64(ref))
+	return ref
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_unref
+// lua-5.3.4/src/lauxlib.c#luaL_unref()
+func (self *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = self.AbsIndex(t)
+		self.RawGetI(t, freelist)
+		self.RawSetI(t, int64(ref)) /* t[ref] = t[freelist] */
+		self.PushInteger(int64(ref))
+		self.RawSetI(t, freelist) /* t[freelist] = ref */
+	}
+}
+
 func (self *luaState) intError(arg int) {
 	if self.IsNumber(arg) {
 		self.ArgError(arg, "number has no integer representation")
@@ -385,10 +675,3 @@ func (self *luaState) typeError(arg int, tname string) int {
 	self.PushString(msg)
 	return self.ArgError(arg, msg)
 }
-
-// [-0, +1, m]
-// http://www.lua.org/manual/5.3/manual.html#luaL_getmetatable
-// lua-5.3.4/src/lauxlib.h#luaL_getmetatable()
-// func (self *luaState) GetMetatable2(tname string) LuaType {
-// 	return self.GetField(LUA_REGISTRYINDEX, tname)
-// }
@@ -0,0 +1,33 @@
+package state
+
+import "testing"
+import "assert"
+import . "luago/api"
+
+func TestRef(t *testing.T) {
+	ls := New()
+
+	ls.PushNil()
+	assert.IntEqual(t, ls.Ref(LUA_REGISTRYINDEX), LUA_REFNIL)
+
+	ls.PushString("a")
+	r1 := ls.Ref(LUA_REGISTRYINDEX)
+	ls.PushString("b")
+	r2 := ls.Ref(LUA_REGISTRYINDEX)
+	if r1 == r2 || r1 <= int(LUA_RIDX_LAST) {
+		t.Errorf("bad refs: %d, %d", r1, r2)
+	}
+	assert.IntEqual(t, ls.GetTop(), 0)
+
+	ls.RawGetI(LUA_REGISTRYINDEX, int64(r2))
+	s, _ := ls.ToString(-1)
+	assert.StringEqual(t, s, "b")
+	ls.Pop(1)
+
+	ls.Unref(LUA_REGISTRYINDEX, r1)
+	ls.PushString("c")
+	assert.IntEqual(t, ls.Ref(LUA_REGISTRYINDEX), r1) // reused
+	ls.RawGetI(LUA_REGISTRYINDEX, int64(r1))
+	s, _ = ls.ToString(-1)
+	assert.StringEqual(t, s, "c")
+}
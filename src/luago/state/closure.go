@@ -7,13 +7,35 @@ type upvalue struct {
 	val *luaValue
 }
 
+/* a function prototype, with its constants converted to values */
+type funcProto struct {
+	*binchunk.Prototype
+	consts []luaValue
+	protos []*funcProto
+}
+
+func newFuncProto(proto *binchunk.Prototype) *funcProto {
+	fp := &funcProto{
+		Prototype: proto,
+		consts:    make([]luaValue, len(proto.Constants)),
+		protos:    make([]*funcProto, len(proto.Protos)),
+	}
+	for i, k := range proto.Constants {
+		fp.consts[i] = valueOf(k)
+	}
+	for i, p := range proto.Protos {
+		fp.protos[i] = newFuncProto(p)
+	}
+	return fp
+}
+
 type closure struct {
-	proto  *binchunk.Prototype // lua closure
-	goFunc GoFunction          // go closure
+	proto  *funcProto // lua closure
+	goFunc GoFunction // go closure
 	upvals []*upvalue
 }
 
-func newLuaClosure(proto *binchunk.Prototype) *closure {
+func newLuaClosure(proto *funcProto) *closure {
 	upvals := make([]*upvalue, len(proto.Upvalues))
 	return &closure{
 		proto:  proto,
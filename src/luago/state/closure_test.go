@@ -0,0 +1,40 @@
+package state
+
+import "strings"
+import "testing"
+
+func TestUpvalues(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	args := strings.Repeat("0, ", 99) + "0"
+	err := ls.DoStringErr(`
+		local n = 0
+		local function inc() n = n + 1 end
+		inc() inc()
+		assert(n == 2, n)
+
+		local function f(...)
+			local x = 1
+			local function getx() return x end
+			local t = {...} -- grows the frame
+			x = 2
+			return getx()
+		end
+		assert(f(` + args + `) == 2)
+
+		local function g(...)
+			local h
+			do
+				local y = 3
+				h = function() return y end
+			end
+			local z = 4 -- reuses the slot of y
+			local t = {...}
+			return h()
+		end
+		assert(g(` + args + `) == 3)`)
+	if err != nil {
+		t.Error(err)
+	}
+}
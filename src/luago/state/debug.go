@@ -5,6 +5,7 @@ import "fmt"
 import "reflect"
 import "runtime"
 import "strings"
+import . "luago/api"
 
 func stackToString(stack *luaStack) string {
 	var buf bytes.Buffer
@@ -19,21 +20,25 @@ func stackToString(stack *luaStack) string {
 }
 
 func valToString(val luaValue) string {
-	switch x := val.(type) {
+	switch x := val.toInterface().(type) {
 	case nil:
 		return "nil"
 	case bool:
-		return fmt.Sprintf("%t", val)
+		return fmt.Sprintf("%t", x)
 	case int64:
-		return fmt.Sprintf("%d", val)
+		return fmt.Sprintf("%d", x)
 	case float64:
-		return fmt.Sprintf("%f", val)
+		return fmt.Sprintf("%f", x)
 	case string:
-		return fmt.Sprintf("%q", val)
+		return fmt.Sprintf("%q", x)
 	case *luaTable:
-		return fmt.Sprintf("{@%p}", val)
+		return fmt.Sprintf("{@%p}", x)
 	case *luaState:
 		return "thread"
+	case *userData:
+		return fmt.Sprintf("udata(%v)", x.data)
+	case lightUserData:
+		return fmt.Sprintf("ludata(%v)", x.p)
 	case *closure:
 		if x.proto != nil {
 			return luaClosureToString(x)
@@ -41,7 +46,7 @@ func valToString(val luaValue) string {
 			return goFuncToString(x.goFunc) + "!"
 		}
 	default:
-		fmt.Printf("%T\n", val)
+		fmt.Printf("%T\n", x)
 		panic("todo!")
 	}
 }
@@ -53,7 +58,7 @@ func luaClosureToString(c *closure) string {
 		c.proto.LastLineDefined)
 }
 
-func goFuncToString(gof luaValue) string {
+func goFuncToString(gof GoFunction) string {
 	pc := reflect.ValueOf(gof).Pointer()
 	if f := runtime.FuncForPC(pc); f != nil {
 		name := f.Name()[strings.LastIndex(f.Name(), ".")+1:]
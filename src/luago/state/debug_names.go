@@ -0,0 +1,226 @@
+package state
+
+import . "luago/api"
+import "luago/vm"
+
+/*
+** Symbolic execution: find a "name" for the value in register reg
+** by looking backwards for the instruction which loaded it.
+ */
+
+// lua-5.3.4/src/ldebug.c#getobjname()
+func getObjName(proto *funcProto, lastPC, reg int) (kind, name string) {
+	if name = _localName(proto, reg+1, lastPC); name != "" { /* is a local? */
+		return "local", name
+	}
+	/* else try symbolic execution */
+	pc := findSetReg(proto, lastPC, reg)
+	if pc == -1 { /* could not find instruction? */
+		return "", ""
+	}
+	i := vm.Instruction(proto.Code[pc])
+	switch op := i.Opcode(); op {
+	case vm.OP_MOVE:
+		a, b, _ := i.ABC() /* move from 'b' to 'a' */
+		if b < a {
+			return getObjName(proto, pc, b) /* get name for 'b' */
+		}
+	case vm.OP_GETTABUP, vm.OP_GETTABLE:
+		_, t, k := i.ABC() /* table index, key index */
+		var vn string      /* name of indexed variable */
+		if op == vm.OP_GETTABLE {
+			vn = _localName(proto, t+1, pc)
+		} else {
+			vn = _upvalName(proto, t)
+		}
+		if vn == "_ENV" {
+			return "global", kName(proto, pc, k)
+		}
+		return "field", kName(proto, pc, k)
+	case vm.OP_GETUPVAL:
+		_, b, _ := i.ABC()
+		return "upvalue", _upvalName(proto, b)
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		_, b := i.ABx()
+		if op == vm.OP_LOADKX {
+			b = vm.Instruction(proto.Code[pc+1]).Ax()
+		}
+		if k := proto.consts[b]; k.tt == LUA_TSTRING {
+			return "constant", k.asString()
+		}
+	case vm.OP_SELF:
+		_, _, k := i.ABC() /* key index */
+		return "method", kName(proto, pc, k)
+	}
+	return "", "" /* could not find reasonable name */
+}
+
+// lua-5.3.4/src/ldebug.c#kname()
+func kName(proto *funcProto, pc, c int) string {
+	if c > 0xFF { /* is 'c' a constant? */
+		if k := proto.consts[c&0xFF]; k.tt == LUA_TSTRING {
+			return k.asString() /* literal constant is its own name */
+		}
+	} else { /* 'c' is a register */
+		if kind, name := getObjName(proto, pc, c); kind == "constant" {
+			return name /* found a constant name */
+		}
+	}
+	return "?" /* no reasonable name found */
+}
+
+// lua-5.3.4/src/ldebug.c#upvalname()
+func _upvalName(proto *funcProto, uv int) string {
+	if uv < len(proto.UpvalueNames) {
+		return proto.UpvalueNames[uv]
+	}
+	return "?"
+}
+
+// Try to find last instruction before 'lastPC' that modified register 'reg'
+// lua-5.3.4/src/ldebug.c#findsetreg()
+func findSetReg(proto *funcProto, lastPC, reg int) int {
+	setReg := -1   /* keep last instruction that changed 'reg' */
+	jmpTarget := 0 /* any code before this address is conditional */
+	filterPC := func(pc int) int {
+		if pc < jmpTarget { /* is code conditional (inside a jump)? */
+			return -1 /* cannot know who sets that register */
+		}
+		return pc /* current position sets that register */
+	}
+	for pc := 0; pc < lastPC; pc++ {
+		i := vm.Instruction(proto.Code[pc])
+		a, b, _ := i.ABC()
+		switch i.Opcode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b { /* set registers from 'a' to 'a+b' */
+				setReg = filterPC(pc)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 { /* affect all regs above its base */
+				setReg = filterPC(pc)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a { /* affect all registers above base */
+				setReg = filterPC(pc)
+			}
+		case vm.OP_JMP:
+			_, sBx := i.AsBx()
+			dest := pc + 1 + sBx
+			/* jump is forward and do not skip 'lastPC'? */
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest /* update 'jmpTarget' */
+			}
+		default:
+			if i.TestAMode() && reg == a { /* any instruction that set A */
+				setReg = filterPC(pc)
+			}
+		}
+	}
+	return setReg
+}
+
+// lua-5.3.4/src/ldebug.c#getfuncname()
+func (self *luaStack) getFuncName() (kind, name string) {
+	caller := self.prev
+	if self.tailCall || caller == nil ||
+		caller.closure == nil || caller.closure.proto == nil {
+		return "", "" /* no way to determine the name */
+	}
+	return caller.funcNameFromCode()
+}
+
+// name of the function called by the current instruction of this frame
+// lua-5.3.4/src/ldebug.c#funcnamefromcode()
+func (self *luaStack) funcNameFromCode() (kind, name string) {
+	if self.hooked { /* was it called inside a hook? */
+		return "hook", "?"
+	}
+	proto := self.closure.proto
+	pc := self.pc - 1 /* calling instruction index */
+	if pc < 0 || pc >= len(proto.Code) {
+		return "", ""
+	}
+	i := vm.Instruction(proto.Code[pc])
+	switch op := i.Opcode(); op {
+	case vm.OP_CALL, vm.OP_TAILCALL: /* get function name */
+		a, _, _ := i.ABC()
+		return getObjName(proto, pc, a)
+	case vm.OP_TFORCALL: /* for iterator */
+		return "for iterator", "for iterator"
+	/* all other instructions can call only through metamethods */
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		return "metamethod", "__index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		return "metamethod", "__newindex"
+	case vm.OP_UNM:
+		return "metamethod", "__unm"
+	case vm.OP_BNOT:
+		return "metamethod", "__bnot"
+	case vm.OP_LEN:
+		return "metamethod", "__len"
+	case vm.OP_CONCAT:
+		return "metamethod", "__concat"
+	case vm.OP_EQ:
+		return "metamethod", "__eq"
+	case vm.OP_LT:
+		return "metamethod", "__lt"
+	case vm.OP_LE:
+		return "metamethod", "__le"
+	default:
+		if op >= vm.OP_ADD && op <= vm.OP_SHR {
+			return "metamethod", operators[op-vm.OP_ADD].metamethod
+		}
+	}
+	return "", ""
+}
+
+// name of a value used as an operand of the current instruction
+// lua-5.3.4/src/ldebug.c#varinfo()
+func (self *luaState) varInfo(val luaValue) string {
+	stack := self.stack
+	c := stack.closure
+	if c == nil || c.proto == nil || stack.pc < 1 {
+		return ""
+	}
+	proto := c.proto
+	pc := stack.pc - 1
+	i := vm.Instruction(proto.Code[pc])
+	a, b, cc := i.ABC()
+
+	var regs []int
+	switch op := i.Opcode(); op {
+	case vm.OP_GETTABUP:
+		return self._upvalInfo(c, b, val)
+	case vm.OP_SETTABUP:
+		return self._upvalInfo(c, a, val)
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		regs = []int{b}
+	case vm.OP_SETTABLE, vm.OP_CALL, vm.OP_TAILCALL:
+		regs = []int{a}
+	case vm.OP_CONCAT:
+		for r := cc; r >= b; r-- { /* the right-most operands are concatenated first */
+			regs = append(regs, r)
+		}
+	default:
+		if op >= vm.OP_ADD && op <= vm.OP_SHR {
+			regs = []int{b, cc}
+		}
+	}
+	for _, reg := range regs {
+		if reg <= 0xFF && reg < stack.size && self.eq(stack.slots[reg], val, true) {
+			if kind, name := getObjName(proto, pc, reg); kind != "" {
+				return " (" + kind + " '" + name + "')"
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+func (self *luaState) _upvalInfo(c *closure, uv int, val luaValue) string {
+	if uv < len(c.upvals) && self.eq(*c.upvals[uv].val, val, true) {
+		return " (upvalue '" + _upvalName(c.proto, uv) + "')"
+	}
+	return ""
+}
@@ -0,0 +1,258 @@
+package state
+
+import "errors"
+import "fmt"
+import "reflect"
+import . "luago/api"
+
+var sliceOfIfaceType = reflect.TypeOf([]interface{}{})
+var mapOfStringIfaceType = reflect.TypeOf(map[string]interface{}{})
+var mapOfIfaceIfaceType = reflect.TypeOf(map[interface{}]interface{}{})
+
+type _visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int /* slices sharing a backing array differ by length */
+}
+
+type _tableVisit struct {
+	tbl *luaTable
+	typ reflect.Type
+}
+
+// [-0, +1, m]
+// Pushes a deep copy of v onto the stack. Structs become tables keyed by
+// field names (or `lua` tags), maps become tables, slices and arrays become
+// sequences; values that have no table counterpart are pushed as PushGoValue
+// does. Signed and unsigned integers become Lua integers, floats become Lua
+// floats.
+func (self *luaState) PushGo(v interface{}) {
+	self.stack.push(self.goToLuaValue(reflect.ValueOf(v), map[_visit]*luaTable{}))
+}
+
+// [-0, +0, –]
+// Stores a copy of r[idx] into the value pointed to by out. Tables are
+// converted to structs, maps, slices or arrays according to out's type.
+// When converted to interface{}, a sequence becomes []interface{} and
+// any other table becomes map[string]interface{} (if all its keys are
+// strings) or map[interface{}]interface{}. Lua functions are converted
+// to Go funcs that call back into the state.
+func (self *luaState) ToGoValue(idx int, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("ToGoValue: out must be a non-nil pointer")
+	}
+	v, err := self.toReflectValue(self.stack.get(idx), rv.Elem().Type(), nil)
+	if err == nil {
+		rv.Elem().Set(v)
+	}
+	return err
+}
+
+/* Go => Lua */
+
+func (self *luaState) goToLuaValue(rv reflect.Value, seen map[_visit]*luaTable) luaValue {
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nilValue
+		}
+		return self.goToLuaValue(rv.Elem(), seen)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nilValue
+		}
+		switch rv.Elem().Kind() {
+		case reflect.Struct, reflect.Array:
+			key := _visit{rv.Pointer(), rv.Type(), 0}
+			if tbl, ok := seen[key]; ok {
+				return tableValue(tbl)
+			}
+			tbl := newLuaTable(0, 0)
+			seen[key] = tbl
+			self.fillTable(tbl, rv.Elem(), seen)
+			return tableValue(tbl)
+		}
+		return self.goToLuaValue(rv.Elem(), seen)
+	case reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			return nilValue
+		}
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return stringValue(string(rv.Bytes()))
+		}
+		tbl := newLuaTable(0, 0)
+		if rv.Kind() == reflect.Map || rv.Len() > 0 { /* empty slices can't hold themselves */
+			key := _visit{rv.Pointer(), rv.Type(), 0}
+			if rv.Kind() == reflect.Slice {
+				key.len = rv.Len()
+			}
+			if tbl, ok := seen[key]; ok {
+				return tableValue(tbl)
+			}
+			seen[key] = tbl
+		}
+		self.fillTable(tbl, rv, seen)
+		return tableValue(tbl)
+	case reflect.Struct, reflect.Array:
+		tbl := newLuaTable(0, 0)
+		self.fillTable(tbl, rv, seen)
+		return tableValue(tbl)
+	default:
+		self.pushReflectValue(rv)
+		return self.stack.pop()
+	}
+}
+
+func (self *luaState) fillTable(tbl *luaTable, rv reflect.Value, seen map[_visit]*luaTable) {
+	switch rv.Kind() {
+	case reflect.Struct:
+		for _, f := range _luaFields(rv.Type()) {
+			tbl.put(stringValue(f.name), self.goToLuaValue(rv.Field(f.index), seen))
+		}
+	case reflect.Map:
+		for _, k := range rv.MapKeys() {
+			if key := self.goToLuaValue(k, seen); !key.isNil() {
+				tbl.put(key, self.goToLuaValue(rv.MapIndex(k), seen))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			tbl.put(intValue(int64(i+1)), self.goToLuaValue(rv.Index(i), seen))
+		}
+	}
+}
+
+/* Lua => Go */
+
+func (self *luaState) tableToStruct(tbl *luaTable, t reflect.Type,
+	seen map[_tableVisit]reflect.Value) (reflect.Value, error) {
+
+	rv := reflect.New(t).Elem()
+	for _, f := range _luaFields(t) {
+		if val := tbl.getStr(f.name); !val.isNil() {
+			fv, err := self.toReflectValue(val, t.Field(f.index).Type, seen)
+			if err != nil {
+				return rv, fmt.Errorf("field '%s': %v", f.name, err)
+			}
+			rv.Field(f.index).Set(fv)
+		}
+	}
+	return rv, nil
+}
+
+func (self *luaState) tableToMap(tbl *luaTable, t reflect.Type,
+	seen map[_tableVisit]reflect.Value) (reflect.Value, error) {
+
+	rv := reflect.MakeMap(t)
+	seen[_tableVisit{tbl, t}] = rv
+	var err error
+	tbl.forEach(func(k, v luaValue) {
+		if err != nil {
+			return
+		}
+		var kv, vv reflect.Value
+		if kv, err = self.toReflectValue(k, t.Key(), seen); err != nil {
+			err = fmt.Errorf("key %v: %v", k.toInterface(), err)
+		} else if vv, err = self.toReflectValue(v, t.Elem(), seen); err != nil {
+			err = fmt.Errorf("field '%v': %v", k.toInterface(), err)
+		} else {
+			rv.SetMapIndex(kv, vv)
+		}
+	})
+	return rv, err
+}
+
+// converts t[1], t[2], ... to the elements of rv (a slice or an array)
+func (self *luaState) tableToElems(tbl *luaTable, rv reflect.Value,
+	seen map[_tableVisit]reflect.Value) error {
+
+	for i := 0; i < rv.Len() && i < tbl.len(); i++ {
+		ev, err := self.toReflectValue(tbl.getInt(int64(i+1)), rv.Type().Elem(), seen)
+		if err != nil {
+			return fmt.Errorf("index %d: %v", i+1, err)
+		}
+		rv.Index(i).Set(ev)
+	}
+	return nil
+}
+
+// the type a table is converted to when the target is interface{}
+func _tableGoType(tbl *luaTable) reflect.Type {
+	n, allStrings := 0, true
+	tbl.forEach(func(k, v luaValue) {
+		n++
+		if k.tt != LUA_TSTRING {
+			allStrings = false
+		}
+	})
+	switch {
+	case n > 0 && n == tbl.len():
+		return sliceOfIfaceType
+	case allStrings:
+		return mapOfStringIfaceType
+	default:
+		return mapOfIfaceIfaceType
+	}
+}
+
+// Returns a Go func of type t which calls c with its arguments converted
+// as PushGoValue does, and converts the results of c back to Go values.
+// If the last result type of t is error, Lua errors are returned as
+// errors; otherwise they are propagated.
+func (self *luaState) luaFuncToGo(c *closure, t reflect.Type) reflect.Value {
+	nOut := t.NumOut()
+	hasErr := nOut > 0 && t.Out(nOut-1) == errorType
+	if hasErr {
+		nOut--
+	}
+	self.gc.exportClosure(c)
+
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		if t.IsVariadic() {
+			last := args[len(args)-1]
+			args = args[:len(args)-1]
+			for i := 0; i < last.Len(); i++ {
+				args = append(args, last.Index(i))
+			}
+		}
+
+		top := self.GetTop()
+		self.CheckStack(len(args) + 1)
+		self.stack.push(closureValue(c))
+		for _, arg := range args {
+			self.pushReflectValue(arg)
+		}
+
+		var err error
+		if !hasErr {
+			self.Call(len(args), nOut)
+		} else if err = self.PCallErr(len(args), nOut, 0); err != nil {
+			self.SetTop(top + nOut)
+		}
+
+		results := make([]reflect.Value, t.NumOut())
+		for i := 0; i < nOut; i++ {
+			rv, _err := self.toReflectValue(self.stack.get(top+1+i), t.Out(i), nil)
+			if _err != nil {
+				if !hasErr {
+					self.SetTop(top)
+					self.Error2("result #%d: %s", i+1, _err.Error())
+				}
+				if err == nil {
+					err = fmt.Errorf("result #%d: %v", i+1, _err)
+				}
+				rv = reflect.Zero(t.Out(i))
+			}
+			results[i] = rv
+		}
+		if hasErr {
+			results[nOut] = reflect.Zero(errorType)
+			if err != nil {
+				results[nOut] = reflect.ValueOf(err)
+			}
+		}
+		self.SetTop(top)
+		return results
+	})
+}
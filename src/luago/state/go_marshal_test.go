@@ -0,0 +1,146 @@
+package state
+
+import "testing"
+import "assert"
+
+type serverConfig struct {
+	Name    string            `lua:"name"`
+	Port    int               `lua:"port"`
+	Ratio   float64           `lua:"ratio"`
+	Hosts   []string          `lua:"hosts"`
+	Headers map[string]string `lua:"headers"`
+	Backup  *serverConfig     `lua:"backup"`
+	Ignored string            `lua:"-"`
+	Handler func(string) (string, error)
+}
+
+func TestToGoValue(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.DoString(`
+		config = {
+			name = "web", port = 8080, ratio = 0.5, Ignored = "x",
+			hosts = {"a", "b"}, headers = {accept = "*/*"},
+			backup = {name = "web2", port = 8081},
+			Handler = function(s)
+				if s == "" then error("empty", 0) end
+				return s:upper()
+			end,
+		}
+		bad = {port = 1.5}
+		mixed = {1, 2.5, "x", {k = true}}
+	`)
+
+	var cfg serverConfig
+	ls.GetGlobal("config")
+	if err := ls.ToGoValue(-1, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	ls.Pop(1)
+	assert.StringEqual(t, cfg.Name, "web")
+	assert.IntEqual(t, cfg.Port, 8080)
+	assert.StringsEqual(t, cfg.Hosts, []string{"a", "b"})
+	assert.StringEqual(t, cfg.Headers["accept"], "*/*")
+	assert.StringEqual(t, cfg.Backup.Name, "web2")
+	assert.StringEqual(t, cfg.Ignored, "")
+	if cfg.Ratio != 0.5 {
+		t.Errorf("ratio: %v", cfg.Ratio)
+	}
+	s, err := cfg.Handler("abc")
+	assert.StringEqual(t, s, "ABC")
+	_, err = cfg.Handler("")
+	assert.StringEqual(t, err.Error(), "empty")
+	assert.IntEqual(t, ls.GetTop(), 0)
+
+	ls.GetGlobal("bad")
+	err = ls.ToGoValue(-1, &cfg)
+	assert.StringEqual(t, err.Error(), "field 'port': int expected, got number")
+	ls.Pop(1)
+
+	var mixed interface{}
+	ls.GetGlobal("mixed")
+	ls.ToGoValue(-1, &mixed)
+	ls.Pop(1)
+	xs := mixed.([]interface{})
+	assert.IntEqual(t, len(xs), 4)
+	if xs[0] != int64(1) || xs[1] != 2.5 || xs[2] != "x" ||
+		xs[3].(map[string]interface{})["k"] != true {
+		t.Errorf("mixed: %v", xs)
+	}
+}
+
+func TestPushGo(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	cfg := &serverConfig{Name: "web", Port: 80, Ratio: 2,
+		Hosts: []string{"a", "b"}, Headers: map[string]string{"k": "v"}}
+	cfg.Backup = cfg
+	ls.PushGo(cfg)
+	ls.SetGlobal("cfg")
+	ls.DoString(`r = table.concat({cfg.name, math.type(cfg.port),
+		math.type(cfg.ratio), #cfg.hosts, cfg.headers.k,
+		tostring(cfg.backup == cfg), tostring(cfg.Ignored)}, ",")`)
+	ls.GetGlobal("r")
+	s, _ := ls.ToString(-1)
+	assert.StringEqual(t, s, "web,integer,float,2,v,true,nil")
+}
+
+func TestToGoValueCycles(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.DoString(`
+		t = {name = "a"}; t.backup = t
+		u = {}; u[1] = u
+		m = {}; m.self = m`)
+
+	var cfg *serverConfig
+	ls.GetGlobal("t")
+	if err := ls.ToGoValue(-1, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backup != cfg || cfg.Name != "a" {
+		t.Errorf("backup: %v", cfg.Backup)
+	}
+
+	var v interface{}
+	ls.GetGlobal("u")
+	if err := ls.ToGoValue(-1, &v); err != nil {
+		t.Fatal(err)
+	}
+	xs := v.([]interface{})
+	if ys, ok := xs[0].([]interface{}); !ok || &ys[0] != &xs[0] {
+		t.Errorf("u: %v", xs[0])
+	}
+
+	var m map[string]interface{}
+	ls.GetGlobal("m")
+	if err := ls.ToGoValue(-1, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m2, ok := m["self"].(map[string]interface{}); !ok || len(m2) != 1 {
+		t.Errorf("m: %v", m["self"])
+	}
+	ls.Pop(3)
+}
+
+func TestPushGoCycles(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	xs := make([]interface{}, 2)
+	xs[0] = xs
+	xs[1] = xs[:1] /* same backing array, another length */
+	ls.PushGo(xs)
+	ls.SetGlobal("xs")
+	m := map[string]interface{}{}
+	m["self"] = m
+	ls.PushGo(m)
+	ls.SetGlobal("m")
+	ls.DoString(`r = table.concat({tostring(xs[1] == xs),
+		tostring(xs[2] ~= xs), #xs[2], tostring(xs[2][1] == xs),
+		tostring(m.self == m)}, ",")`)
+	ls.GetGlobal("r")
+	s, _ := ls.ToString(-1)
+	assert.StringEqual(t, s, "true,true,1,true,true")
+}
@@ -0,0 +1,516 @@
+package state
+
+import "fmt"
+import "math"
+import "reflect"
+import . "luago/api"
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// [-0, +1, m]
+// Pushes v onto the stack. Booleans, numbers and strings become Lua values,
+// funcs become Go functions, everything else is wrapped as a full userdata
+// whose metatable is generated from v's type. Structs and arrays are copied
+// and wrapped as pointers, so that their fields and elements can be set;
+// those reached through a pushed pointer (such as a field of a struct) are
+// not copied, so setting them changes the Go value. Unsigned integers that
+// do not fit in a Lua integer become floats.
+func (self *luaState) PushGoValue(v interface{}) {
+	self.pushReflectValue(reflect.ValueOf(v))
+}
+
+func (self *luaState) pushReflectValue(rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Invalid:
+		self.PushNil()
+	case reflect.Bool:
+		self.PushBoolean(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		self.PushInteger(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u <= math.MaxInt64 {
+			self.PushInteger(int64(u))
+		} else {
+			self.PushNumber(float64(u))
+		}
+	case reflect.Float32, reflect.Float64:
+		self.PushNumber(rv.Float())
+	case reflect.String:
+		self.PushString(rv.String())
+	case reflect.Interface:
+		if rv.IsNil() {
+			self.PushNil()
+		} else {
+			self.pushReflectValue(rv.Elem())
+		}
+	case reflect.Func:
+		if rv.IsNil() {
+			self.PushNil()
+		} else {
+			self.pushGoFunc(rv)
+		}
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan:
+		if rv.IsNil() {
+			self.PushNil()
+		} else {
+			self.pushGoObject(rv)
+		}
+	case reflect.Struct, reflect.Array:
+		if rv.CanAddr() { /* part of a Go value */
+			self.pushGoObject(rv.Addr())
+			return
+		}
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		self.pushGoObject(ptr)
+	default:
+		self.pushGoObject(rv)
+	}
+}
+
+func (self *luaState) pushGoObject(rv reflect.Value) {
+	ud := newUserData(rv.Interface())
+	ud.metatable = self.goMetatable(rv.Type())
+	self.stack.push(userDataValue(ud))
+}
+
+func (self *luaState) pushGoFunc(fn reflect.Value) {
+	self.PushGoFunction(func(ls LuaState) int {
+		return ls.(*luaState).callGoFunc(fn, 1)
+	})
+}
+
+// calls fn with the arguments r[base], r[base+1], ... and pushes
+// its results; a non-nil error as the last result raises an error
+func (self *luaState) callGoFunc(fn reflect.Value, base int) int {
+	t := fn.Type()
+	nIn := t.NumIn()
+	nArgs := self.GetTop() - base + 1
+
+	args := make([]reflect.Value, 0, nIn)
+	for i := 0; i < nIn; i++ {
+		if t.IsVariadic() && i == nIn-1 {
+			for j := i; j < nArgs; j++ {
+				args = append(args, self.checkReflectValue(base+j, t.In(i).Elem()))
+			}
+		} else {
+			args = append(args, self.checkReflectValue(base+i, t.In(i)))
+		}
+	}
+
+	results := fn.Call(args)
+	if n := len(results); n > 0 && t.Out(n-1) == errorType {
+		if err := results[n-1]; !err.IsNil() {
+			return self.Error2("%s", err.Interface().(error).Error())
+		}
+		results = results[:n-1]
+	}
+
+	self.CheckStack(len(results))
+	for _, result := range results {
+		self.pushReflectValue(result)
+	}
+	return len(results)
+}
+
+func (self *luaState) checkReflectValue(arg int, t reflect.Type) reflect.Value {
+	rv, err := self.toReflectValue(self.stack.get(arg), t, nil)
+	if err != nil {
+		self.ArgError(arg, err.Error())
+	}
+	return rv
+}
+
+// converts a Lua value to a Go value of type t; seen maps the tables
+// being converted to pointers, maps and slices to the values built for
+// them, so that cyclic tables become cyclic Go values (nil is fine)
+func (self *luaState) toReflectValue(val luaValue, t reflect.Type,
+	seen map[_tableVisit]reflect.Value) (reflect.Value, error) {
+
+	tbl := val.asTable()
+	if tbl != nil {
+		if rv, ok := seen[_tableVisit{tbl, t}]; ok {
+			return rv, nil /* converted or being converted */
+		}
+		if seen == nil {
+			seen = map[_tableVisit]reflect.Value{}
+		}
+	}
+	if ud := val.asUserData(); ud != nil && ud.data != nil {
+		rv := reflect.ValueOf(ud.data)
+		if rv.Type().AssignableTo(t) {
+			return rv, nil
+		}
+		if rv.Kind() == reflect.Ptr && rv.Elem().Type().AssignableTo(t) {
+			return rv.Elem(), nil // copied struct or array
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return reflect.ValueOf(convertToBoolean(val)).Convert(t), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := convertToInteger(val); ok {
+			rv := reflect.New(t).Elem()
+			if rv.OverflowInt(i) {
+				return rv, fmt.Errorf("%s out of range", t)
+			}
+			rv.SetInt(i)
+			return rv, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := convertToInteger(val); ok {
+			rv := reflect.New(t).Elem()
+			if i < 0 || rv.OverflowUint(uint64(i)) {
+				return rv, fmt.Errorf("%s out of range", t)
+			}
+			rv.SetUint(uint64(i))
+			return rv, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := convertToFloat(val); ok {
+			return reflect.ValueOf(f).Convert(t), nil
+		}
+	case reflect.String:
+		switch val.tt {
+		case LUA_TSTRING:
+			return reflect.ValueOf(val.asString()).Convert(t), nil
+		case LUA_TNUMINT, LUA_TNUMFLT:
+			return reflect.ValueOf(fmt.Sprintf("%v", val.toInterface())).Convert(t), nil
+		}
+	case reflect.Interface:
+		if val.isNil() {
+			return reflect.Zero(t), nil
+		}
+		if tbl != nil && t.NumMethod() == 0 {
+			return self.toReflectValue(val, _tableGoType(tbl), seen)
+		}
+		if v, ok := toGoInterface(val); ok {
+			if rv := reflect.ValueOf(v); rv.Type().Implements(t) {
+				return rv, nil
+			}
+		}
+	case reflect.Ptr:
+		if val.isNil() {
+			return reflect.Zero(t), nil
+		}
+		ptr := reflect.New(t.Elem())
+		if tbl != nil {
+			seen[_tableVisit{tbl, t}] = ptr
+		}
+		if elem, err := self.toReflectValue(val, t.Elem(), seen); err == nil {
+			ptr.Elem().Set(elem)
+			return ptr, nil
+		}
+		delete(seen, _tableVisit{tbl, t})
+	case reflect.Struct:
+		if tbl != nil {
+			return self.tableToStruct(tbl, t, seen)
+		}
+	case reflect.Map:
+		if tbl != nil {
+			return self.tableToMap(tbl, t, seen)
+		}
+	case reflect.Slice:
+		if val.tt == LUA_TSTRING && t.Elem().Kind() == reflect.Uint8 {
+			return reflect.ValueOf([]byte(val.asString())).Convert(t), nil
+		}
+		if tbl != nil {
+			rv := reflect.MakeSlice(t, tbl.len(), tbl.len())
+			seen[_tableVisit{tbl, t}] = rv
+			return rv, self.tableToElems(tbl, rv, seen)
+		}
+	case reflect.Array:
+		if tbl != nil {
+			rv := reflect.New(t).Elem()
+			return rv, self.tableToElems(tbl, rv, seen)
+		}
+	case reflect.Func:
+		if val.isNil() {
+			return reflect.Zero(t), nil
+		}
+		if c := val.asClosure(); c != nil {
+			return self.luaFuncToGo(c, t), nil
+		}
+	case reflect.Chan:
+		if val.isNil() {
+			return reflect.Zero(t), nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("%s expected, got %s",
+		t, self.TypeName(typeOf(val)))
+}
+
+func toGoInterface(val luaValue) (interface{}, bool) {
+	switch x := val.o.(type) {
+	case nil, string: /* booleans and numbers are not kept in 'o' */
+		return val.toInterface(), val.tt != LUA_TNIL
+	case *userData:
+		return x.data, x.data != nil
+	case lightUserData:
+		return x.p, x.p != nil
+	default:
+		return nil, false
+	}
+}
+
+/* metatables of Go values */
+
+func (self *luaState) goMetatable(t reflect.Type) *luaTable {
+	key := lightUserDataValue(t)
+	if mt := self.registry.get(key).asTable(); mt != nil {
+		return mt
+	}
+
+	self.CreateTable(0, 8)
+	self.PushString(t.String())
+	self.SetField(-2, "__name")
+	self.pushGoMethods(t)
+	self.PushGoClosure(goIndex, 1)
+	self.SetField(-2, "__index")
+	self.PushGoFunction(goNewIndex)
+	self.SetField(-2, "__newindex")
+
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Chan:
+		self.PushGoFunction(goLen)
+		self.SetField(-2, "__len")
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Array {
+			self.PushGoFunction(goLen)
+			self.SetField(-2, "__len")
+		}
+	}
+	switch _indirectKind(t) {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		self.PushGoFunction(goPairs)
+		self.SetField(-2, "__pairs")
+	}
+	if t.Comparable() {
+		self.PushGoFunction(goEq)
+		self.SetField(-2, "__eq")
+	}
+	if t.Implements(stringerType) || t.Implements(errorType) {
+		self.PushGoFunction(goToString)
+		self.SetField(-2, "__tostring")
+	}
+
+	mt := self.stack.pop().asTable()
+	self.registry.put(key, tableValue(mt))
+	return mt
+}
+
+// methods are called with the receiver as the first
+// argument, so that obj:Method(...) works as expected
+func (self *luaState) pushGoMethods(t reflect.Type) {
+	self.CreateTable(0, t.NumMethod())
+	for i := 0; i < t.NumMethod(); i++ {
+		idx := i
+		self.PushGoFunction(func(ls LuaState) int {
+			_ls := ls.(*luaState)
+			recv := _ls.checkGoObject(1, t)
+			return _ls.callGoFunc(recv.Method(idx), 2)
+		})
+		self.SetField(-2, t.Method(i).Name)
+	}
+}
+
+func (self *luaState) checkGoObject(arg int, t reflect.Type) reflect.Value {
+	if ud := self.stack.get(arg).asUserData(); ud != nil && ud.data != nil {
+		if rv := reflect.ValueOf(ud.data); t == nil || rv.Type() == t {
+			return rv
+		}
+	}
+	if t == nil {
+		self.typeError(arg, "Go value")
+	} else {
+		self.typeError(arg, t.String())
+	}
+	return reflect.Value{}
+}
+
+func _indirectKind(t reflect.Type) reflect.Kind {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem().Kind()
+	}
+	return t.Kind()
+}
+
+type luaField struct {
+	name  string
+	index int
+}
+
+// exported fields of a struct type, named by their `lua` tags if any;
+// fields tagged with "-" are ignored
+func _luaFields(t reflect.Type) []luaField {
+	fields := make([]luaField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tag := f.Tag.Get("lua"); f.PkgPath == "" && tag != "-" {
+			if tag == "" {
+				tag = f.Name
+			}
+			fields = append(fields, luaField{tag, i})
+		}
+	}
+	return fields
+}
+
+// looks up a struct field by its `lua` tag or by its name
+func _fieldByName(v reflect.Value, name string) reflect.Value {
+	for _, f := range _luaFields(v.Type()) {
+		if f.name == name {
+			return v.Field(f.index)
+		}
+	}
+	if f, ok := v.Type().FieldByName(name); ok && f.PkgPath == "" && f.Tag.Get("lua") != "-" {
+		return v.FieldByIndex(f.Index)
+	}
+	return reflect.Value{}
+}
+
+// index of array or slice, from 1 to len
+func _elemIndex(ls *luaState, idx int, v reflect.Value) (int, bool) {
+	if i, ok := ls.ToIntegerX(idx); ok && i >= 1 && i <= int64(v.Len()) {
+		return int(i - 1), true
+	}
+	return 0, false
+}
+
+// __index(obj, key)
+func goIndex(ls LuaState) int {
+	self := ls.(*luaState)
+	rv := self.checkGoObject(1, nil)
+
+	if ls.Type(2) == LUA_TSTRING { /* method? */
+		ls.PushValue(2)
+		if ls.RawGet(LuaUpvalueIndex(1)) != LUA_TNIL {
+			return 1
+		}
+		ls.Pop(1)
+	}
+
+	v := reflect.Indirect(rv)
+	switch v.Kind() {
+	case reflect.Struct:
+		if name, ok := ls.ToString(2); ok && ls.Type(2) == LUA_TSTRING {
+			if f := _fieldByName(v, name); f.IsValid() {
+				self.pushReflectValue(f)
+				return 1
+			}
+		}
+	case reflect.Array, reflect.Slice:
+		if i, ok := _elemIndex(self, 2, v); ok {
+			self.pushReflectValue(v.Index(i))
+			return 1
+		}
+	case reflect.Map:
+		if key, err := self.toReflectValue(self.stack.get(2), v.Type().Key(), nil); err == nil {
+			if val := v.MapIndex(key); val.IsValid() {
+				self.pushReflectValue(val)
+				return 1
+			}
+		}
+	}
+
+	ls.PushNil()
+	return 1
+}
+
+// __newindex(obj, key, val)
+func goNewIndex(ls LuaState) int {
+	self := ls.(*luaState)
+	rv := self.checkGoObject(1, nil)
+
+	v := reflect.Indirect(rv)
+	switch v.Kind() {
+	case reflect.Struct:
+		name, _ := ls.ToString(2)
+		if f := _fieldByName(v, name); f.IsValid() && f.CanSet() && ls.Type(2) == LUA_TSTRING {
+			f.Set(self.checkReflectValue(3, f.Type()))
+			return 0
+		}
+		return ls.Error2("no field '%s' in %s", name, v.Type())
+	case reflect.Array, reflect.Slice:
+		if i, ok := _elemIndex(self, 2, v); ok {
+			v.Index(i).Set(self.checkReflectValue(3, v.Type().Elem()))
+			return 0
+		}
+		return ls.Error2("index out of range")
+	case reflect.Map:
+		key := self.checkReflectValue(2, v.Type().Key())
+		if ls.IsNil(3) {
+			v.SetMapIndex(key, reflect.Value{})
+		} else {
+			v.SetMapIndex(key, self.checkReflectValue(3, v.Type().Elem()))
+		}
+		return 0
+	}
+
+	return ls.Error2("cannot set field of %s", rv.Type())
+}
+
+// __len(obj)
+func goLen(ls LuaState) int {
+	rv := reflect.Indirect(ls.(*luaState).checkGoObject(1, nil))
+	ls.PushInteger(int64(rv.Len()))
+	return 1
+}
+
+// __pairs(obj)
+func goPairs(ls LuaState) int {
+	self := ls.(*luaState)
+	v := reflect.Indirect(self.checkGoObject(1, nil))
+
+	var keys []reflect.Value
+	if v.Kind() == reflect.Map {
+		keys = v.MapKeys()
+	}
+	i := 0
+	ls.PushGoFunction(func(ls LuaState) int {
+		self := ls.(*luaState)
+		if v.Kind() != reflect.Map {
+			if i < v.Len() {
+				i++
+				ls.PushInteger(int64(i))
+				self.pushReflectValue(v.Index(i - 1))
+				return 2
+			}
+			return 0
+		}
+		for ; i < len(keys); i++ {
+			if val := v.MapIndex(keys[i]); val.IsValid() {
+				self.pushReflectValue(keys[i])
+				self.pushReflectValue(val)
+				i++
+				return 2
+			}
+		}
+		return 0
+	})
+	ls.PushValue(1)
+	ls.PushNil()
+	return 3
+}
+
+// __eq(a, b)
+func goEq(ls LuaState) int {
+	ls.PushBoolean(ls.ToUserData(1) == ls.ToUserData(2))
+	return 1
+}
+
+// __tostring(obj)
+func goToString(ls LuaState) int {
+	switch x := ls.ToUserData(1).(type) {
+	case fmt.Stringer:
+		ls.PushString(x.String())
+	case error:
+		ls.PushString(x.Error())
+	default:
+		ls.PushNil()
+	}
+	return 1
+}
@@ -0,0 +1,110 @@
+package state
+
+import "errors"
+import "strings"
+import "testing"
+import "assert"
+import . "luago/api"
+
+type vec2 struct{ X, Y int }
+
+type shape struct {
+	Origin vec2
+	Arr    [3]int
+	Big    uint64
+}
+
+type account struct {
+	Owner   string `lua:"owner"`
+	Balance int
+	secret  string
+	Tags    []string
+	Limits  map[string]int
+}
+
+func (self *account) Deposit(n int) int {
+	self.Balance += n
+	return self.Balance
+}
+
+func (self *account) Withdraw(n int) (int, error) {
+	if n > self.Balance {
+		return self.Balance, errors.New("insufficient funds")
+	}
+	self.Balance -= n
+	return self.Balance, nil
+}
+
+func (self *account) String() string {
+	return self.Owner
+}
+
+func TestPushGoValue(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	acc := &account{Owner: "bob", Balance: 10, secret: "x",
+		Tags: []string{"a", "b"}, Limits: map[string]int{"day": 5}}
+	ls.PushGoValue(acc)
+	ls.SetGlobal("acc")
+	ls.PushGoValue(strings.Repeat)
+	ls.SetGlobal("rep")
+	ls.PushGoValue(func(xs ...int) (sum int) {
+		for _, x := range xs {
+			sum += x
+		}
+		return
+	})
+	ls.SetGlobal("sum")
+
+	ls.DoString(`
+		local ok, err = pcall(acc.Withdraw, acc, 100)
+		local s = {}
+		for _, tag in ipairs(acc.Tags) do s[#s+1] = tag end
+		acc.Tags[2] = "c"
+		acc.Limits.week = 20
+		acc.Limits.day = nil
+		acc.owner = "alice"
+		r = table.concat({acc.owner, acc:Deposit(5), tostring(ok), err,
+			#acc.Tags, table.concat(s), tostring(acc.secret), tostring(acc),
+			rep("ab", 3), sum(1, 2, 3), sum()}, ",")
+	`)
+	ls.GetGlobal("r")
+	s, _ := ls.ToString(-1)
+	assert.StringEqual(t, s, "alice,15,false,insufficient funds,2,ab,nil,alice,ababab,6,0")
+	ls.Pop(1)
+
+	assert.StringEqual(t, acc.Tags[1], "c")
+	assert.IntEqual(t, acc.Limits["week"], 20)
+	assert.IntEqual(t, len(acc.Limits), 1)
+
+	ls.PushGoValue(acc)
+	if ls.ToUserData(-1) != acc {
+		t.Errorf("userdata should hold the Go value")
+	}
+	ls.PushGoValue(acc)
+	if !ls.Compare(-1, -2, LUA_OPEQ) {
+		t.Errorf("wrappers of the same pointer should be equal")
+	}
+}
+
+func TestPushGoValueNested(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	sh := &shape{Big: 1<<63 + 5}
+	ls.PushGoValue(sh)
+	ls.SetGlobal("sh")
+	err := ls.DoStringErr(`
+		sh.Origin.X = 5
+		sh.Arr[2] = 9
+		local o = sh.Origin
+		o.Y = 7
+		assert(math.type(sh.Big) == "float" and sh.Big > 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IntEqual(t, sh.Origin.X, 5)
+	assert.IntEqual(t, sh.Origin.Y, 7)
+	assert.IntEqual(t, sh.Arr[1], 9)
+}
@@ -0,0 +1,103 @@
+package state
+
+import "fmt"
+import . "luago/api"
+
+// converts a recovered panic value to *LuaError, recording where
+// the error was raised if not done yet; the stack frames and the
+// traceback are only built with trace, as they are costly
+func (self *luaState) toLuaError(r interface{}, trace bool) *LuaError {
+	var err *LuaError
+	switch x := r.(type) {
+	case *LuaError:
+		err = x
+	case *quotaError:
+		err = &LuaError{Status: LUA_ERRQUOTA, Value: x.msg}
+	default: // runtime error
+		err = &LuaError{Status: LUA_ERRRUN, Value: self.addInfo(_errorMessage(r))}
+	}
+
+	if err.ChunkName == "" {
+		err.ChunkName, err.Line = self.currentPosition()
+		if ud, ok := err.Value.(*userData); ok {
+			err.Cause, _ = ud.data.(error)
+		}
+	}
+	if trace && err.Traceback == "" {
+		err.Frames = self.StackFrames(0)
+		err.Traceback = _formatTraceback(err.Frames)
+	}
+	return err
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_typeerror()
+func (self *luaState) objTypeError(val luaValue, op string) {
+	self.checkOpen() /* a closed state has no globals, metatables... */
+	typeName := self.TypeName(typeOf(val))
+	panic("attempt to " + op + " a " + typeName + " value" + self.varInfo(val))
+}
+
+// Error when both values are convertible to numbers, but not to integers
+// lua-5.3.4/src/ldebug.c#luaG_tointerror()
+func (self *luaState) toIntError(a, b luaValue) {
+	if _, ok := convertToInteger(a); ok {
+		a = b
+	}
+	panic("number has no integer representation" + self.varInfo(a))
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_opinterror()
+func (self *luaState) opIntError(a, b luaValue, msg string) {
+	if _, ok := convertToFloat(a); ok { /* first operand is OK? */
+		a = b /* now second is wrong too */
+	}
+	self.objTypeError(a, msg)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_concaterror()
+func (self *luaState) concatError(a, b luaValue) {
+	switch typeOf(a) {
+	case LUA_TSTRING, LUA_TNUMBER:
+		a = b
+	}
+	self.objTypeError(a, "concatenate")
+}
+
+// prefixes msg with the position of the running function, if it is
+// a Lua function
+// lua-5.3.4/src/ldebug.c#luaG_runerror()
+func (self *luaState) addInfo(msg string) string {
+	if c := self.stack.closure; c != nil && c.proto != nil {
+		src := ChunkID(c.proto.Source)
+		return fmt.Sprintf("%s:%d: %s", src, self.stack.currentLine(), msg)
+	}
+	return msg
+}
+
+func _errorMessage(r interface{}) string {
+	switch x := r.(type) {
+	case string:
+		return x
+	case error:
+		return x.Error()
+	default:
+		return "unknown error"
+	}
+}
+
+// chunk and current line of the innermost running Lua function
+func (self *luaState) currentPosition() (string, int) {
+	for stack := self.stack; stack != nil; stack = stack.prev {
+		if c := stack.closure; c != nil && c.proto != nil {
+			return ChunkID(c.proto.Source), stack.currentLine()
+		}
+	}
+	return "[C]", -1
+}
+
+func (self *luaStack) currentLine() int {
+	if lineInfo := self.closure.proto.LineInfo; self.pc > 0 && self.pc <= len(lineInfo) {
+		return int(lineInfo[self.pc-1])
+	}
+	return -1
+}
@@ -0,0 +1,197 @@
+package state
+
+import "context"
+import "encoding/json"
+import "errors"
+import "strings"
+import "testing"
+import "assert"
+import . "luago/api"
+
+func TestLuaError(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr("local x = 1\nlocal function f()\n  error('boom', 0)\nend\nf()")
+	var lerr *LuaError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("*LuaError expected, got %v", err)
+	}
+	assert.IntEqual(t, lerr.Status, LUA_ERRRUN)
+	assert.StringEqual(t, lerr.Error(), "boom")
+	assert.IntEqual(t, lerr.Line, 3)
+	if lerr.ChunkName != `[string "local x = 1..."]` {
+		t.Errorf("chunk name: %s", lerr.ChunkName)
+	}
+	if !strings.Contains(lerr.Traceback, "in main chunk") ||
+		!strings.Contains(lerr.Traceback, "in local 'f'") {
+		t.Errorf("traceback: %s", lerr.Traceback)
+	}
+	assert.IntEqual(t, len(lerr.Frames), 3)
+	assert.StringEqual(t, lerr.Frames[0].Function, "function 'error'")
+	assert.StringEqual(t, lerr.Frames[0].GoFunction, "baseError()")
+	assert.IntEqual(t, lerr.Frames[1].Line, 3)
+	assert.IntEqual(t, ls.GetTop(), 0)
+
+	err = ls.DoStringErr("x = = 1")
+	errors.As(err, &lerr)
+	assert.IntEqual(t, lerr.Status, LUA_ERRSYNTAX)
+
+	err = ls.DoStringErr("return 1")
+	if err != nil {
+		t.Errorf("nil expected, got %v", err)
+	}
+
+	// Go errors raised as userdata can be unwrapped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ls.SetContext(ctx)
+	err = ls.DoStringErr("while true do end")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("context.Canceled expected, got %v", err)
+	}
+}
+
+func TestMessageHandler(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	line := 0
+	ls.PushGoFunction(func(ls LuaState) int {
+		// the faulting frames are still live
+		_, line = ls.(*luaState).currentPosition()
+		ls.PushString("handled: " + ls.ToString2(1))
+		return 1
+	})
+	ls.LoadString("local x = 1\nerror('boom', 0)")
+	assert.IntEqual(t, ls.PCall(0, 0, 1), LUA_ERRRUN)
+	assert.StringEqual(t, ls.ToString2(-1), "handled: boom")
+	assert.IntEqual(t, line, 2)
+	ls.SetTop(0)
+
+	ls.PushGoFunction(func(ls LuaState) int {
+		ls.PushString("oops")
+		return ls.Error()
+	})
+	ls.LoadString("error('boom', 0)")
+	assert.IntEqual(t, ls.PCall(0, 0, -2), LUA_ERRERR)
+	assert.StringEqual(t, ls.ToString2(-1), "error in error handling")
+	assert.IntEqual(t, ls.GetTop(), 3)
+}
+
+func TestVarInfo(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	tests := []struct{ code, msg string }{
+		{"return x + 1", "test:1: attempt to perform arithmetic on a nil value (global 'x')"},
+		{"local t = {} return t.a.b", "test:1: attempt to index a nil value (field 'a')"},
+		{"local a; a.b = 1", "test:1: attempt to index a nil value (local 'a')"},
+		{"foo()", "test:1: attempt to call a nil value (global 'foo')"},
+		{"local t = {} t:bar()", "test:1: attempt to call a nil value (method 'bar')"},
+		{"return 'a' .. y .. 'b'", "test:1: attempt to concatenate a nil value (global 'y')"},
+		{"return #z", "test:1: attempt to get length of a nil value (global 'z')"},
+		{"return {} | 1", "test:1: attempt to perform bitwise operation on a table value"},
+		{"local x = 1.5 return x | 1", "test:1: number has no integer representation (local 'x')"},
+		{"string.rep()", "test:1: bad argument #1 to 'rep' (string expected, got no value)"},
+		{"('x'):rep({})", "test:1: bad argument #1 to 'rep' (number expected, got table)"},
+	}
+	for _, test := range tests {
+		ls.Load([]byte(test.code), "=test", "t")
+		err := ls.PCallErr(0, 0, 0)
+		if err == nil || err.Error() != test.msg {
+			t.Errorf("%s: %v", test.code, err)
+		}
+	}
+}
+
+func TestErrorLevels(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	tests := []struct{ level, msg string }{
+		{"", "test:2: boom"},
+		{", 1", "test:2: boom"},
+		{", 2", "test:4: boom"},
+		{", 0", "boom"},
+	}
+	for _, test := range tests {
+		code := "local function f()\n  error('boom'" + test.level + ")\nend\nf()"
+		ls.Load([]byte(code), "=test", "t")
+		err := ls.PCallErr(0, 0, 0)
+		if err == nil || err.Error() != test.msg {
+			t.Errorf("level %s: %v", test.level, err)
+		}
+	}
+
+	ls.Load([]byte("local t = {}\nt[nil] = 1"), "@test.lua", "t")
+	err := ls.PCallErr(0, 0, 0)
+	assert.StringEqual(t, err.Error(), "test.lua:2: table index is nil!")
+
+	ls.PushGoFunction(func(ls LuaState) int {
+		ls.Where(1)
+		return 1
+	})
+	ls.SetGlobal("where")
+	ls.Load([]byte("\nreturn where()"), "=test", "t")
+	ls.Call(0, 1)
+	assert.StringEqual(t, ls.ToString2(-1), "test:2: ")
+}
+
+func TestTraceback(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	ls.Register("tb", func(ls LuaState) int {
+		ls.Traceback(ls, ls.OptString(1, ""), 1)
+		return 1
+	})
+	ls.Load([]byte(`
+		local function deep(n)
+			if n == 0 then return (tb("msg")) end
+			return (deep(n - 1))
+		end
+		function t() return (tb()) end
+		return deep(30), t()`), "=test", "t")
+	ls.Call(0, 2)
+
+	lines := strings.Split(ls.ToString2(1), "\n")
+	assert.IntEqual(t, len(lines), 2+10+1+11)
+	assert.StringEqual(t, lines[0], "msg")
+	assert.StringEqual(t, lines[1], "stack traceback:")
+	assert.StringEqual(t, lines[2], "\ttest:3: in upvalue 'deep'")
+	assert.StringEqual(t, lines[12], "\t...")
+	assert.StringEqual(t, lines[22], "\ttest:4: in local 'deep'")
+	assert.StringEqual(t, lines[23], "\ttest:7: in main chunk")
+	assert.StringEqual(t, ls.ToString2(2),
+		"stack traceback:\n\ttest:6: in function 't'\n\ttest:7: in main chunk")
+	ls.SetTop(0)
+
+	ls.Load([]byte("return coroutine.create(function() coroutine.yield() end)"), "=co", "t")
+	ls.Call(0, 1)
+	co := ls.ToThread(1)
+	co.Resume(ls, 0)
+	frames := co.StackFrames(0)
+	data, _ := json.Marshal(frames)
+	assert.StringEqual(t, string(data), `[{"source":"[C]","line":-1,`+
+		`"function":"function 'coroutine.yield'","gofunction":"coYield()"},`+
+		`{"source":"co","line":1,"function":"function \u003cco:1\u003e","linedefined":1}]`)
+}
+
+func TestTracebackOnlyForHosts(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	ls.LoadString(`error("boom")`)
+	_, err := ls.(*luaState).pcall(0, 0, 0, false)
+	if err.Traceback != "" || err.Frames != nil || err.Line != 1 {
+		t.Errorf("traceback built for a script error: %+v", err)
+	}
+	ls.SetTop(0)
+
+	ls.LoadString(`error("boom")`)
+	lerr := ls.PCallErr(0, 0, 0).(*LuaError)
+	if !strings.HasPrefix(lerr.Traceback, "stack traceback:") || len(lerr.Frames) == 0 {
+		t.Errorf("traceback: %q", lerr.Traceback)
+	}
+}
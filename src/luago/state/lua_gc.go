@@ -0,0 +1,457 @@
+package state
+
+import "fmt"
+import "runtime"
+import "runtime/metrics"
+import "sort"
+import "sync"
+import "sync/atomic"
+import "weak"
+import . "luago/api"
+
+/*
+** Memory is reclaimed by Go's collector; this file implements the
+** parts of the Lua collector that scripts can see: finalizers and
+** the 'collectgarbage' options.
+** Tables and userdata whose metatables have a '__gc' field when set
+** are marked for finalization. When Go finds a marked object
+** unreachable, its finalizer goroutine queues it (resurrecting it),
+** and the owning state calls the '__gc' metamethods of the queued
+** objects at safe points (allocations), in reverse order of marking.
+** Closing the state calls the finalizers of all marked objects,
+** reachable or not.
+** Go never finalizes an object that can reach itself (e.g., one
+** captured by its own '__gc' function), so full collections also
+** trace the values reachable from the state; marked objects that are
+** not reached lose their Go finalizers and are finalized as if Go had
+** queued them, after which Go can reclaim them. Lua functions passed
+** to Go as funcs are roots while Go keeps them. The tracing treats
+** tables with weak keys as ephemeron tables (see lua_table_weak.go).
+ */
+
+type gcState struct {
+	mu      sync.Mutex
+	queued  []fnzObject /* unreachable marked objects, from the finalizer goroutine */
+	pending atomic.Bool /* is 'queued' not empty? */
+	closed  bool        /* the state was closed */
+	/* owned by the state's goroutine */
+	finobj  []fnzObject             /* weak references to marked objects */
+	nFinobj int                     /* prune 'finobj' when it reaches this size */
+	tobefnz []fnzObject             /* objects to be finalized, last marked first */
+	goFuncs []weak.Pointer[closure] /* Lua functions converted to Go funcs */
+	nMarked int64                   /* number of markings so far */
+	running bool                    /* false: collector stopped */
+	pause   int
+	stepmul int
+}
+
+type fnzObject struct {
+	obj luaValue /* *luaTable or *userData */
+	seq int64    /* order of marking */
+}
+
+func newGCState() *gcState {
+	return &gcState{running: true, pause: 200, stepmul: 200}
+}
+
+func (self *gcState) enqueue(obj luaValue, seq int64) {
+	self.mu.Lock()
+	if !self.closed {
+		self.queued = append(self.queued, fnzObject{obj, seq})
+		self.pending.Store(true)
+	}
+	self.mu.Unlock()
+}
+
+/*
+** if object 'o' has a finalizer, mark it for finalization; marked
+** objects are not marked again until their finalizers run
+ */
+// lua-5.3.4/src/lgc.c#luaC_checkfinalizer()
+func (self *luaState) checkFinalizer(o luaValue, mt *luaTable) {
+	if mt == nil || mt.getStr("__gc").isNil() {
+		return /* no finalizer */
+	}
+	g := self.gc
+	seq := g.nMarked + 1
+	switch x := o.o.(type) {
+	case *luaTable:
+		if x.toFinalize {
+			return /* already marked */
+		}
+		x.toFinalize = true
+		runtime.SetFinalizer(x, func(x *luaTable) { g.enqueue(tableValue(x), seq) })
+	case *userData:
+		if x.toFinalize {
+			return /* already marked */
+		}
+		x.toFinalize = true
+		runtime.SetFinalizer(x, func(x *userData) { g.enqueue(userDataValue(x), seq) })
+	default:
+		return
+	}
+	g.nMarked = seq
+	if len(g.finobj) >= g.nFinobj { /* drop references to finalized objects */
+		live := g.finobj[:0]
+		for _, o := range g.finobj {
+			if !_strengthen(o.obj).isNil() {
+				live = append(live, o)
+			}
+		}
+		clear(g.finobj[len(live):])
+		g.finobj = live
+		g.nFinobj = max(2*len(live), 64)
+	}
+	g.finobj = append(g.finobj, fnzObject{_weaken(o), seq})
+}
+
+/* calls pending finalizers at a safe point, unless the collector is stopped */
+// lua-5.3.4/src/lgc.h#luaC_checkGC()
+func (self *luaState) checkGC() {
+	if g := self.gc; g.running && g.pending.Load() {
+		self.callAllPendingFinalizers()
+	}
+}
+
+/*
+** moves the queued objects to 'tobefnz'; with 'all', moves all
+** marked objects, which are then not finalized by Go anymore
+ */
+// lua-5.3.4/src/lgc.c#separatetobefnz()
+func (self *luaState) separateToBeFnz(all bool) {
+	g := self.gc
+	g.mu.Lock()
+	fnz := g.queued
+	g.queued = nil
+	g.pending.Store(false)
+	g.mu.Unlock()
+
+	if all {
+		for _, o := range g.finobj {
+			obj := _strengthen(o.obj)
+			switch x := obj.o.(type) {
+			case *luaTable:
+				runtime.SetFinalizer(x, nil)
+			case *userData:
+				runtime.SetFinalizer(x, nil)
+			default: /* finalized */
+				continue
+			}
+			fnz = append(fnz, fnzObject{obj, o.seq})
+		}
+		g.finobj = nil
+	}
+	if len(fnz) > 0 {
+		g.tobefnz = append(g.tobefnz, fnz...)
+		sort.Slice(g.tobefnz, func(i, j int) bool {
+			return g.tobefnz[i].seq > g.tobefnz[j].seq
+		})
+	}
+}
+
+// lua-5.3.4/src/lgc.c#callallpendingfinalizers()
+func (self *luaState) callAllPendingFinalizers() {
+	self.separateToBeFnz(false)
+	for g := self.gc; len(g.tobefnz) > 0; {
+		self.gcTM(g.nextToBeFnz(), true)
+	}
+}
+
+/* calls the finalizers of all marked objects, ignoring their errors */
+// lua-5.3.4/src/lgc.c#luaC_freeallobjects()
+func (self *luaState) freeAllObjects() {
+	self.separateToBeFnz(true)
+	for g := self.gc; len(g.tobefnz) > 0; {
+		self.gcTM(g.nextToBeFnz(), false)
+	}
+}
+
+func (self *gcState) nextToBeFnz() luaValue {
+	o := self.tobefnz[0].obj
+	self.tobefnz[0] = fnzObject{}
+	self.tobefnz = self.tobefnz[1:]
+	return o
+}
+
+// lua-5.3.4/src/lgc.c#GCTM()
+func (self *luaState) gcTM(o luaValue, propagateErrors bool) {
+	switch x := o.o.(type) { /* may be marked again */
+	case *luaTable:
+		x.toFinalize = false
+	case *userData:
+		x.toFinalize = false
+	}
+	tm := getMetafield(o, "__gc", self)
+	if tm.tt != LUA_TFUNCTION {
+		return /* not a function */
+	}
+	g := self.gc
+	oldRunning, oldInHook := g.running, self.inHook
+	g.running = false  /* avoid GC steps */
+	self.inHook = true /* avoid hooks */
+	self.stack.check(2)
+	self.stack.push(tm)
+	self.stack.push(o)
+	self.nny++ /* finalizers cannot yield */
+	status, err := self.pcall(1, 0, 0, false)
+	self.nny--
+	g.running, self.inHook = oldRunning, oldInHook
+	if err != nil { /* error while running __gc? */
+		self.stack.pop() /* remove error object */
+		if !propagateErrors {
+			return
+		}
+		if status == LUA_ERRRUN {
+			msg, ok := err.Value.(string)
+			if !ok {
+				msg = "no message"
+			}
+			err = &LuaError{Status: LUA_ERRGCMM,
+				Value: fmt.Sprintf("error in __gc metamethod (%s)", msg)}
+		}
+		panic(err)
+	}
+}
+
+// sentinels tell when the finalizers of a collection were queued
+type sentinel struct{ _ *sentinel }
+
+/*
+** runs a complete collection and then the finalizers it made
+** pending. Go queues finalizers after a collection and runs them in
+** batches; the first sentinel shows that the batch with the marked
+** objects was taken, and the second one, queued in a later batch,
+** that it was done.
+ */
+// lua-5.3.4/src/lgc.c#luaC_fullgc()
+func (self *luaState) fullGC() {
+	for i := 0; i < 2; i++ {
+		done := make(chan struct{})
+		runtime.SetFinalizer(&sentinel{}, func(*sentinel) { close(done) })
+		runtime.GC()
+		<-done
+	}
+	if self.atomic() { /* entries of weak tables were cleared? */
+		runtime.GC() /* reclaim what they kept */
+	}
+	self.callAllPendingFinalizers()
+}
+
+/* keeps c reachable for the tracing of full collections while Go keeps it */
+func (self *gcState) exportClosure(c *closure) {
+	self.goFuncs = append(self.goFuncs, weak.Make(c))
+}
+
+/*
+** traces the objects reachable from the registry, the running
+** threads, the objects to be finalized and the Lua functions kept by
+** Go. Marked objects that are not reached, which Go could not queue
+** because they are part of reference cycles, move to 'tobefnz' and
+** are then marked, as they will be resurrected. Last, the entries of
+** tables with weak keys whose keys were not reached are cleared;
+** returns whether there were such entries.
+ */
+// lua-5.3.4/src/lgc.c#atomic()
+func (self *luaState) atomic() bool {
+	g := self.gc
+	live := g.finobj[:0]
+	for _, o := range g.finobj {
+		if !_strengthen(o.obj).isNil() {
+			live = append(live, o)
+		}
+	}
+	clear(g.finobj[len(live):])
+	g.finobj = live
+
+	m := &gcMarker{marked: map[interface{}]bool{}}
+	m.mark(tableValue(self.registry))
+	for t := self; t != nil; t = t.coCaller {
+		m.mark(threadValue(t))
+	}
+	for _, o := range g.tobefnz {
+		m.mark(o.obj)
+	}
+	g.mu.Lock()
+	for _, o := range g.queued {
+		m.mark(o.obj)
+	}
+	g.mu.Unlock()
+	goFuncs := g.goFuncs[:0]
+	for _, p := range g.goFuncs {
+		if c := p.Value(); c != nil {
+			m.mark(closureValue(c))
+			goFuncs = append(goFuncs, p)
+		}
+	}
+	clear(g.goFuncs[len(goFuncs):])
+	g.goFuncs = goFuncs
+	m.propagate()
+	m.convergeEphemerons()
+
+	/* separate the objects to be finalized */
+	live = g.finobj[:0]
+	var fnz []fnzObject
+	for _, o := range g.finobj {
+		obj := _strengthen(o.obj)
+		if m.marked[obj.o] {
+			live = append(live, o)
+			continue
+		}
+		switch x := obj.o.(type) {
+		case *luaTable:
+			runtime.SetFinalizer(x, nil)
+		case *userData:
+			runtime.SetFinalizer(x, nil)
+		}
+		fnz = append(fnz, fnzObject{obj, o.seq})
+	}
+	clear(g.finobj[len(live):])
+	g.finobj = live
+	if len(fnz) > 0 {
+		g.tobefnz = append(g.tobefnz, fnz...)
+		sort.Slice(g.tobefnz, func(i, j int) bool {
+			return g.tobefnz[i].seq > g.tobefnz[j].seq
+		})
+		for _, o := range fnz { /* mark objects that will be finalized */
+			m.mark(o.obj)
+		}
+		m.propagate()
+		m.convergeEphemerons()
+	}
+	return m.clearKeys()
+}
+
+/* marks the objects reachable from some values through strong references */
+type gcMarker struct {
+	marked    map[interface{}]bool
+	gray      []luaValue
+	ephemeron []*luaTable /* traversed tables with weak keys only */
+}
+
+func (self *gcMarker) mark(val luaValue) {
+	switch val.o.(type) {
+	case *luaTable, *closure, *userData, *luaState:
+		if !self.marked[val.o] {
+			self.marked[val.o] = true
+			self.gray = append(self.gray, val)
+		}
+	} /* weak references and values that are not objects */
+}
+
+// lua-5.3.4/src/lgc.c#propagatemark()
+func (self *gcMarker) propagate() {
+	for len(self.gray) > 0 {
+		val := self.gray[len(self.gray)-1]
+		self.gray = self.gray[:len(self.gray)-1]
+		switch x := val.o.(type) {
+		case *luaTable:
+			if x.metatable != nil {
+				self.mark(tableValue(x.metatable))
+			}
+			for _, v := range x.arr {
+				self.mark(v)
+			}
+			if x.weakKeys && !x.weakValues {
+				self.ephemeron = append(self.ephemeron, x)
+				self.traverseEphemeron(x)
+				continue
+			}
+			for i := range x.node {
+				self.mark(x.node[i].key)
+				self.mark(x.node[i].val)
+			}
+		case *closure:
+			for _, uv := range x.upvals {
+				if uv != nil {
+					self.mark(*uv.val)
+				}
+			}
+		case *userData:
+			if x.metatable != nil {
+				self.mark(tableValue(x.metatable))
+			}
+			self.mark(x.userValue)
+		case *luaState:
+			self.markThread(x)
+		}
+	}
+}
+
+/* is val not an object or a reached one? */
+func (self *gcMarker) reached(val luaValue) bool {
+	switch val.o.(type) {
+	case *luaTable, *closure, *userData, *luaState:
+		return self.marked[val.o]
+	}
+	return true
+}
+
+/*
+** marks the values of the live entries whose keys were reached;
+** returns whether some value was marked
+ */
+// lua-5.3.4/src/lgc.c#traverseephemeron()
+func (self *gcMarker) traverseEphemeron(t *luaTable) bool {
+	nGray := len(self.gray)
+	for i := range t.node {
+		if k, v := t.entry(&t.node[i]); !v.isNil() && self.reached(k) {
+			self.mark(v)
+		}
+	}
+	return len(self.gray) > nGray
+}
+
+/* marks the values of ephemeron tables until no more keys are reached */
+// lua-5.3.4/src/lgc.c#convergeephemerons()
+func (self *gcMarker) convergeEphemerons() {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(self.ephemeron); i++ {
+			if self.traverseEphemeron(self.ephemeron[i]) {
+				self.propagate() /* may find more ephemeron tables */
+				changed = true
+			}
+		}
+	}
+}
+
+/* clears the entries of ephemeron tables whose keys were not reached */
+// lua-5.3.4/src/lgc.c#clearkeys()
+func (self *gcMarker) clearKeys() bool {
+	cleared := false
+	for _, t := range self.ephemeron {
+		for i := range t.node {
+			n := &t.node[i]
+			if k, v := t.entry(n); !v.isNil() && !self.reached(k) {
+				n.val = nilValue /* the entry is dead */
+				cleared = true
+			}
+		}
+	}
+	return cleared
+}
+
+func (self *gcMarker) markThread(t *luaState) {
+	if t.stack == nil {
+		return
+	}
+	for _, v := range t.slots[:min(t.stack.base+t.stack.top, len(t.slots))] {
+		self.mark(v)
+	}
+	for stack := t.stack; stack != nil; stack = stack.prev {
+		if stack.closure != nil {
+			self.mark(closureValue(stack.closure))
+		}
+		self.mark(stack.handler)
+		for _, v := range stack.saved {
+			self.mark(v)
+		}
+	}
+}
+
+/* approximate size of the heap, shared by all Go code */
+func _heapSize() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,198 @@
+package state
+
+import "os"
+import "strings"
+import "testing"
+import . "luago/api"
+
+func TestFinalizers(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local order = {}
+		local mt = {__gc = function(o) order[#order + 1] = o.id end}
+		local function mark(n)
+			for i = 1, n do setmetatable({id = i}, mt) end
+		end
+		mark(5)
+		collectgarbage()
+		assert(table.concat(order, " ") == "5 4 3 2 1", table.concat(order, " "))
+
+		local n = 0
+		local mt2 = {}
+		mt2.__gc = function(o) -- resurrected objects may be marked again
+			n = n + 1
+			if n < 3 then setmetatable(o, mt2) end
+		end
+		local function resurrect() setmetatable({}, mt2) end
+		resurrect()
+		for i = 1, 4 do collectgarbage() end
+		assert(n == 3)
+
+		local function bad() setmetatable({}, {__gc = function() error("!!") end}) end
+		bad()
+		local ok, msg = pcall(collectgarbage)
+		assert(not ok and msg:find("error in __gc metamethod %(.*!!%)"), msg)
+
+		assert(collectgarbage("isrunning") and collectgarbage("step"))
+		collectgarbage("stop")
+		assert(not collectgarbage("isrunning"))
+		collectgarbage("restart")
+		assert(collectgarbage("isrunning"))
+		assert(collectgarbage("count") > 0)
+		ok, msg = pcall(collectgarbage, "bogus")
+		assert(not ok and msg:find("invalid option 'bogus'"))`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFinalizerCycles(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local n = 0
+		local mt = {__gc = function(o) n = n + 1 end}
+		local refs = setmetatable({}, {__mode = "k"})
+		local function make()
+			local o = setmetatable({}, mt)
+			o.self = o
+			refs[o] = true
+			local p = {inc = 10}
+			setmetatable(p, {__gc = function() n = n + p.inc end})
+		end
+		make()
+		kept = setmetatable({}, mt)
+		kept.self = kept
+		collectgarbage()
+		assert(n == 11, n)
+		collectgarbage()
+		assert(next(refs) == nil) -- reclaimed after its finalizer ran
+		kept = nil
+		collectgarbage()
+		assert(n == 12, n)`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEphemerons(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local proxies = setmetatable({}, {__mode = "k"})
+		local function make()
+			for i = 1, 1000 do
+				local o = {}
+				proxies[o] = setmetatable({}, {__index = o})
+			end
+		end
+		make()
+		collectgarbage()
+		assert(next(proxies) == nil)
+
+		local a = {}
+		local function chain() -- values keep the keys of other entries
+			local b, c = {}, {}
+			proxies[c] = a
+			proxies[b] = c
+			proxies[a] = b
+		end
+		local function check() -- leaves no keys in dead registers
+			local n = 0
+			for k, v in pairs(proxies) do n = n + 1 end
+			return n == 3 and proxies[proxies[proxies[a]]] == a
+		end
+		chain()
+		collectgarbage()
+		assert(check())
+		a = nil
+		collectgarbage()
+		assert(next(proxies) == nil)`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	var closed []string
+	ls.Register("record", func(ls LuaState) int {
+		closed = append(closed, ls.CheckString(1))
+		return 0
+	})
+
+	err := ls.DoStringErr(`
+		local mt = {__gc = function(o) record(o.name) end}
+		a = setmetatable({name = "a"}, mt)
+		b = setmetatable({name = "b"}, mt)
+		setmetatable({}, {__gc = function() error("ignored") end})
+		co = coroutine.wrap(function() coroutine.yield() end)
+		co()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	co := ls.NewThread()
+	ls.Close()
+	ls.Close() /* no effect */
+	if strings.Join(closed, " ") != "b a" {
+		t.Errorf("finalizers: %v", closed)
+	}
+
+	for _, f := range []func(){
+		func() { ls.PushInteger(1) },
+		func() { ls.GetGlobal("a") },
+		func() { ls.DoString("return 1") },
+		func() { ls.ToInteger(1) },
+		func() { co.Resume(ls, 0) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != "attempt to use a closed Lua state" {
+					t.Errorf("recovered: %v", r)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestCloseFiles(t *testing.T) {
+	dir := t.TempDir()
+	openFiles := func() int { /* 0 where /proc is missing */
+		fds, _ := os.ReadDir("/proc/self/fd")
+		return len(fds)
+	}
+	nFiles := openFiles()
+	ls := New()
+	ls.OpenLibs()
+	ls.PushString(dir)
+	ls.SetGlobal("dir")
+
+	err := ls.DoStringErr(`
+		local f = assert(io.open(dir .. "/a.txt", "w"))
+		assert(f:write("kept ", 1, " ", 2.5) == f)
+		files = {f}
+		for i = 1, 3 do
+			files[#files + 1] = io.lines(dir .. "/a.txt")
+		end
+		io.output(dir .. "/b.txt")
+		io.write("default")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.Close() /* flushes and closes all of them */
+	if n := openFiles(); n != nFiles {
+		t.Errorf("%d files left open", n-nFiles)
+	}
+
+	for name, data := range map[string]string{"a.txt": "kept 1 2.5", "b.txt": "default"} {
+		if b, err := os.ReadFile(dir + "/" + name); err != nil || string(b) != data {
+			t.Errorf("%s: %q %v", name, b, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package state
+
+import . "luago/api"
+
+// lua-5.3.4/src/ldo.c#luaD_hook()
+func (self *luaState) callHook(event, line int) {
+	if self.hook == nil || self.inHook {
+		return
+	}
+
+	top := self.stack.top
+	self.stack.check(LUA_MINSTACK)
+	stack := self.stack
+	self.inHook = true /* cannot call hooks inside a hook */
+	stack.hooked = true
+	self.nny++ /* hooks cannot yield */
+	defer func() {
+		self.inHook, stack.hooked = false, false
+		self.nny--
+	}()
+
+	ar := &LuaDebug{Event: event, CurrentLine: line, CallInfo: stack}
+	self.hook(self, ar)
+	self.SetTop(top)
+}
+
+// called after a new frame was pushed
+func (self *luaState) hookCall() {
+	if self.hookMask&LUA_MASKCALL != 0 {
+		event := LUA_HOOKCALL
+		if self.stack.tailCall {
+			event = LUA_HOOKTAILCALL
+		}
+		self.callHook(event, -1)
+	}
+}
+
+// called before the current frame is popped
+func (self *luaState) hookReturn() {
+	if self.hookMask&LUA_MASKRET != 0 {
+		self.callHook(LUA_HOOKRET, -1)
+	}
+}
+
+// called before executing an instruction of a Lua function
+// lua-5.3.4/src/ldebug.c#luaG_traceexec()
+func (self *luaState) traceExec() {
+	if self.hookMask&LUA_MASKCOUNT != 0 {
+		if self.hookCount--; self.hookCount == 0 {
+			self.hookCount = self.baseHookCount /* reset count */
+			self.callHook(LUA_HOOKCOUNT, -1)
+		}
+	}
+	if self.hookMask&LUA_MASKLINE != 0 {
+		stack := self.stack
+		lineInfo := stack.closure.proto.LineInfo
+		npc := stack.pc - 1
+		if npc >= len(lineInfo) || stack.oldPC >= len(lineInfo) {
+			return /* no debug information */
+		}
+		newLine := int(lineInfo[npc])
+		if npc == 0 || /* call linehook when enter a new function, */
+			npc <= stack.oldPC || /* when jump back (loop), or when */
+			newLine != int(lineInfo[stack.oldPC]) { /* enter a new line */
+			self.callHook(LUA_HOOKLINE, newLine)
+		}
+		stack.oldPC = npc
+	}
+}
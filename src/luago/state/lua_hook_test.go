@@ -0,0 +1,77 @@
+package state
+
+import "strconv"
+import "strings"
+import "testing"
+import "assert"
+import . "luago/api"
+
+func TestHooks(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	var events []string
+	ls.SetHook(func(ls LuaState, ar *LuaDebug) {
+		switch ar.Event {
+		case LUA_HOOKCALL:
+			events = append(events, "call")
+		case LUA_HOOKRET:
+			events = append(events, "return")
+		case LUA_HOOKTAILCALL:
+			events = append(events, "tail call")
+		}
+	}, LUA_MASKCALL|LUA_MASKRET, 0)
+	assert.IntEqual(t, ls.GetHookMask(), LUA_MASKCALL|LUA_MASKRET)
+
+	ls.LoadString("local function f() end\nlocal function g() return f() end\ng()")
+	events = nil
+	ls.Call(0, 0)
+	if s := strings.Join(events, ","); !strings.HasPrefix(s, "call,call,tail call,return,return") {
+		t.Errorf("unexpected events: %s", s)
+	}
+
+	var lines []int
+	ls.SetHook(func(ls LuaState, ar *LuaDebug) {
+		lines = append(lines, ar.CurrentLine)
+	}, LUA_MASKLINE, 0)
+	ls.DoString("local x = 1\nfor i = 1, 2 do\n  x = x + i\nend")
+	assert.StringEqual(t, fmtInts(lines), "1 2 3 2 3 2 4")
+
+	n := 0
+	ls.SetHook(func(ls LuaState, ar *LuaDebug) { n++ }, LUA_MASKCOUNT, 10)
+	assert.IntEqual(t, ls.GetHookCount(), 10)
+	ls.DoString("for i = 1, 100 do end")
+	if n < 10 {
+		t.Errorf("count hook called %d times", n)
+	}
+
+	ls.SetHook(nil, LUA_MASKLINE, 0)
+	if ls.GetHook() != nil || ls.GetHookMask() != 0 {
+		t.Error("hook not turned off")
+	}
+}
+
+func TestDebugSetHook(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	ls.DoString(`
+		local n = 0
+		debug.sethook(function(ev) n = n + 1 end, "", 10)
+		for i = 1, 100 do end
+		local _, mask, count = debug.gethook()
+		debug.sethook()
+		result = n .. mask .. count .. tostring(debug.gethook())`)
+	ls.GetGlobal("result")
+	if s, _ := ls.ToString(-1); !strings.HasSuffix(s, "10nil") || s[0] == '0' {
+		t.Errorf("unexpected result: %s", s)
+	}
+}
+
+func fmtInts(a []int) string {
+	s := make([]string, len(a))
+	for i, x := range a {
+		s[i] = strconv.Itoa(x)
+	}
+	return strings.Join(s, " ")
+}
@@ -2,37 +2,58 @@ package state
 
 import . "luago/api"
 
+/*
+** A thread keeps the values of all its running functions in a single
+** stack, 'luaState.slots'. A frame (a CallInfo in PUC Lua) is a window
+** into it that starts at 'base', the first register of the function;
+** the arguments of a call become the first slots of the callee's
+** frame and its results are moved down to the slot of the function.
+** Slots above the top of the innermost frame are nil.
+ */
 type luaStack struct {
 	/* virtual stack */
-	slots []luaValue
+	slots []luaValue // state.slots[base:], may be moved by reallocStack
+	base  int
 	top   int
+	size  int // slots reserved for the frame, a limit for top
 	/* call info */
-	state   *luaState
-	closure *closure
-	varargs []luaValue
-	openuvs map[int]*upvalue
-	pc      int
+	state    *luaState
+	closure  *closure
+	fn       int // index of the called function in state.slots
+	nVarargs int // number of extra arguments, kept below base
+	openuvs  map[int]*upvalue
+	nResults int // expected number of results from this function
+	pc       int
+	oldPC    int  // pc of the last instruction traced by line hooks
+	hooked   bool // running a hook
+	leq      bool // using __lt for __le
+	tailCall bool // called by a tail call
+	/* continuation of Go functions */
+	k       KFunction
+	ctx     KContext
+	ypcall  bool       // doing a yieldable protected call
+	handler luaValue   // message handler of the yieldable protected call
+	oldTop  int        // top to restore if the yieldable protected call fails
+	saved   []luaValue // values below the yielded ones
 	/* linked list */
 	prev *luaStack
+	next *luaStack // a frame no longer in use, for the next call
 }
 
-func newLuaStack(size int, state *luaState) *luaStack {
-	return &luaStack{
-		state: state,
-		slots: make([]luaValue, size),
-	}
-}
-
+// makes room for n more values, moving the thread's stack if needed
 func (self *luaStack) check(n int) {
-	free := len(self.slots) - self.top
-	for i := free; i < n; i++ {
-		self.slots = append(self.slots, nil)
+	if self.top+n <= self.size {
+		return
 	}
+	self.state.checkOpen()
+	self.state.checkStackSize(self.top + n)
+	self.state.growStack(self.base + self.top + n)
+	self.size = self.top + n
 }
 
 func (self *luaStack) push(val luaValue) {
-	if self.top == len(self.slots) {
-		panic("stack overflow!")
+	if self.top == self.size {
+		self.check(1)
 	}
 	self.slots[self.top] = val
 	self.top++
@@ -40,11 +61,12 @@ func (self *luaStack) push(val luaValue) {
 
 func (self *luaStack) pop() luaValue {
 	if self.top < 1 {
+		self.state.checkOpen()
 		panic("stack underflow!")
 	}
 	self.top--
 	val := self.slots[self.top]
-	self.slots[self.top] = nil
+	self.slots[self.top] = nilValue
 	return val
 }
 
@@ -58,7 +80,7 @@ func (self *luaStack) pushN(vals []luaValue, n int) {
 		if i < nVals {
 			self.push(vals[i])
 		} else {
-			self.push(nil)
+			self.push(nilValue)
 		}
 	}
 }
@@ -90,7 +112,11 @@ func (self *luaStack) isValid(idx int) bool {
 		return true
 	}
 	absIdx := self.absIndex(idx)
-	return absIdx > 0 && absIdx <= self.top
+	if absIdx > 0 && absIdx <= self.top {
+		return true
+	}
+	self.state.checkOpen()
+	return false
 }
 
 func (self *luaStack) get(idx int) luaValue {
@@ -98,20 +124,21 @@ func (self *luaStack) get(idx int) luaValue {
 		uvIdx := LUA_REGISTRYINDEX - idx - 1
 		c := self.closure
 		if c == nil || uvIdx >= len(c.upvals) {
-			return nil
+			return nilValue
 		}
 		return *(c.upvals[uvIdx].val)
 	}
 
 	if idx == LUA_REGISTRYINDEX {
-		return self.state.registry
+		return tableValue(self.state.registry)
 	}
 
 	absIdx := self.absIndex(idx)
 	if absIdx > 0 && absIdx <= self.top {
 		return self.slots[absIdx-1]
 	}
-	return nil
+	self.state.checkOpen()
+	return nilValue
 }
 
 func (self *luaStack) set(idx int, val luaValue) {
@@ -119,13 +146,13 @@ func (self *luaStack) set(idx int, val luaValue) {
 		uvIdx := LUA_REGISTRYINDEX - idx - 1
 		c := self.closure
 		if c != nil && uvIdx < len(c.upvals) {
-			c.upvals[uvIdx].val = &val
+			*(c.upvals[uvIdx].val) = val
 		}
 		return
 	}
 
 	if idx == LUA_REGISTRYINDEX {
-		self.state.registry = val.(*luaTable)
+		self.state.registry = val.asTable()
 		return
 	}
 
@@ -134,6 +161,7 @@ func (self *luaStack) set(idx int, val luaValue) {
 		self.slots[absIdx-1] = val
 		return
 	}
+	self.state.checkOpen()
 	panic("todo!")
 }
 
@@ -145,3 +173,40 @@ func (self *luaStack) reverse(from, to int) {
 		to--
 	}
 }
+
+// the extra arguments of a vararg function
+func (self *luaStack) varargs() []luaValue {
+	return self.state.slots[self.base-self.nVarargs : self.base]
+}
+
+// returns the open upvalue of slot idx, shared by all the closures
+// that capture it
+// lua-5.3.4/src/lfunc.c#luaF_findupval()
+func (self *luaStack) findUpvalue(idx int) *upvalue {
+	if uv := self.openuvs[idx]; uv != nil {
+		return uv
+	}
+	if self.openuvs == nil {
+		self.openuvs = map[int]*upvalue{}
+	}
+	uv := &upvalue{&self.slots[idx]}
+	self.openuvs[idx] = uv
+	return uv
+}
+
+// closes the open upvalues of the slots from 'from' on
+// lua-5.3.4/src/lfunc.c#luaF_close()
+func (self *luaStack) closeUpvalues(from int) {
+	for i, openuv := range self.openuvs {
+		if i >= from {
+			val := *openuv.val
+			openuv.val = &val
+			delete(self.openuvs, i)
+		}
+	}
+}
+
+// reports whether the frame runs a Go function
+func (self *luaStack) isGo() bool {
+	return self.closure != nil && self.closure.proto == nil
+}
@@ -1,49 +1,207 @@
 package state
 
+import "context"
 import . "luago/api"
 
 type luaState struct {
 	/* global state */
 	panicf   GoFunction
 	registry *luaTable
+	gc       *gcState
 	/* stack */
-	stack     *luaStack
+	slots     []luaValue // values of all frames
+	stack     *luaStack  // frame of the running function
+	overflow  bool       // handling a stack overflow
 	callDepth int
+	nCcalls   int // number of nested Go calls and resumes
+	/* cancellation */
+	ctx context.Context
+	/* quotas */
+	limits  Limits
+	nInsts  int64 // instructions executed by the outermost PCall
+	nPCalls int   // number of running PCalls
+	/* hooks */
+	hook          LuaHook
+	hookMask      int
+	baseHookCount int
+	hookCount     int
+	inHook        bool
 	/* coroutine */
 	coStatus ThreadStatus
-	coCaller *luaState
-	coChan   chan int
+	coCaller *luaState // thread that resumed this one, while it runs
+	nny      int       // number of non-yieldable calls in stack
 }
 
 func New() LuaState {
-	ls := &luaState{}
+	ls := &luaState{nny: 1} /* main thread is never yieldable */
 
 	registry := newLuaTable(8, 0)
-	registry.put(LUA_RIDX_MAINTHREAD, ls)
-	registry.put(LUA_RIDX_GLOBALS, newLuaTable(0, 20))
+	registry.put(intValue(LUA_RIDX_MAINTHREAD), threadValue(ls))
+	registry.put(intValue(LUA_RIDX_GLOBALS), tableValue(newLuaTable(0, 20)))
 
 	ls.registry = registry
-	ls.pushLuaStack(newLuaStack(LUA_MINSTACK, ls))
+	ls.gc = newGCState()
+	ls.pushLuaStack(-1, 0, LUA_MINSTACK)
 	return ls
 }
 
+/*
+** calls the finalizers of all objects marked for finalization, which
+** closes the files opened by the io library, and drops everything the
+** state refers to; threads and other objects are then reclaimed by
+** Go's collector
+ */
+// lua-5.3.4/src/lstate.c#close_state()
+func (self *luaState) closeState() {
+	base := self.stack
+	for base.prev != nil {
+		base = base.prev
+	}
+	self.unwind(base, 0) /* unwind running calls */
+	self.SetHook(nil, 0, 0)
+	self.nny, self.nCcalls = 1, 0
+	self.freeAllObjects()
+
+	g := self.gc
+	g.mu.Lock()
+	g.closed = true
+	g.queued = nil
+	g.mu.Unlock()
+	g.finobj, g.tobefnz = nil, nil
+	*self.registry = luaTable{}         /* shared by all threads */
+	self.stack = &luaStack{state: self} /* using it fails */
+	self.slots, self.callDepth = nil, 0
+}
+
+// called where API calls on a closed state end up, to fail there
+func (self *luaState) checkOpen() {
+	if self.gc.closed {
+		panic("attempt to use a closed Lua state")
+	}
+}
+
 func (self *luaState) isMainThread() bool {
-	return self.registry.get(LUA_RIDX_MAINTHREAD) == self
+	return self.registry.getInt(LUA_RIDX_MAINTHREAD).asThread() == self
 }
 
-func (self *luaState) pushLuaStack(stack *luaStack) {
-	stack.prev = self.stack
+// pushes a frame for the function at index fn of the stack, with
+// size slots from base
+// lua-5.3.4/src/lstate.c#luaE_extendCI()
+func (self *luaState) pushLuaStack(fn, base, size int) *luaStack {
+	if self.callDepth >= LUAI_MAXCALLS {
+		self.stackOverflow(self.callDepth >= ERRORCALLS)
+	}
+	if max := self.limits.MaxCallDepth; max > 0 && self.callDepth >= max {
+		self.quotaError("call depth limit exceeded")
+	}
+	self.checkStackSize(size)
+	self.growStack(base + size)
+	caller := self.stack
+	var stack *luaStack
+	if caller != nil && caller.next != nil { /* reuse a frame */
+		stack = caller.next
+		*stack = luaStack{next: stack.next}
+	} else {
+		stack = &luaStack{}
+		if caller != nil {
+			caller.next = stack
+		}
+	}
+	stack.state = self
+	stack.slots = self.slots[base:]
+	stack.base = base
+	stack.size = size
+	stack.fn = fn
+	stack.prev = caller
 	self.stack = stack
 	self.callDepth++
+	return stack
 }
 
 func (self *luaState) popLuaStack() {
 	stack := self.stack
+	stack.closeUpvalues(0)
 	self.stack = stack.prev
 	stack.prev = nil
 	self.callDepth--
 }
 
+// pops the frames above caller and sets its top, clearing the
+// slots that were in use above it
+func (self *luaState) unwind(caller *luaStack, top int) {
+	end := self.stack.base + self.stack.top
+	for self.stack != caller {
+		self.popLuaStack()
+	}
+	caller.closeUpvalues(top)
+	if from := caller.base + top; from < end {
+		clear(self.slots[from:end])
+	}
+	caller.top = top
+}
+
+/* extra space for handling a stack overflow */
+const (
+	ERRORSTACKSIZE = LUAI_MAXSTACK + 200
+	ERRORCALLS     = LUAI_MAXCALLS + 200
+)
+
+// makes room for size slots in the stack, raising "stack overflow"
+// when the thread would use more than LUAI_MAXSTACK slots
+// lua-5.3.4/src/ldo.c#luaD_growstack()
+func (self *luaState) growStack(size int) {
+	if size <= len(self.slots) {
+		return
+	}
+	if size > LUAI_MAXSTACK {
+		self.stackOverflow(size > ERRORSTACKSIZE)
+	}
+	self.reallocStack(max(size, min(2*len(self.slots), ERRORSTACKSIZE)))
+}
+
+// moves the stack to a new array of the given size, and the frames
+// and open upvalues with it
+// lua-5.3.4/src/ldo.c#luaD_reallocstack()
+func (self *luaState) reallocStack(newSize int) {
+	slots := make([]luaValue, newSize)
+	copy(slots, self.slots)
+	self.slots = slots
+	for stack := self.stack; stack != nil; stack = stack.prev {
+		stack.slots = slots[stack.base:]
+		for idx, uv := range stack.openuvs {
+			uv.val = &stack.slots[idx]
+		}
+	}
+}
+
+// raises "stack overflow"; error handlers may then use the extra
+// space, and exhausting it too is an error in error handling
+func (self *luaState) stackOverflow(exhausted bool) {
+	if !self.overflow {
+		self.overflow = true
+		panic("stack overflow")
+	} else if exhausted {
+		panic(&LuaError{Status: LUA_ERRERR, Value: "error in error handling"})
+	}
+}
+
+// called after an error was handled, to give up the extra space
+// when the stack is small enough again
+// lua-5.3.4/src/ldo.c#luaD_shrinkstack()
+func (self *luaState) shrinkStack() {
+	inUse := 0 /* slots reserved by the frames */
+	for stack := self.stack; stack != nil; stack = stack.prev {
+		inUse = max(inUse, stack.base+stack.size)
+	}
+	if self.overflow && inUse+inUse/8 < LUAI_MAXSTACK &&
+		self.callDepth+self.callDepth/8 < LUAI_MAXCALLS {
+		self.overflow = false
+	}
+	if goodSize := inUse + inUse/8 + 2*LUA_MINSTACK; goodSize < len(self.slots)/2 {
+		self.reallocStack(goodSize) /* drop space used by deep calls */
+	}
+}
+
 // debug
 func (self *luaState) String() string {
 	return stackToString(self.stack)
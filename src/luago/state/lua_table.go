@@ -1,153 +1,505 @@
 package state
 
+import "hash/maphash"
 import "math"
+import "math/bits"
+import . "luago/api"
 import "luago/number"
 
-type pair struct {
-	key luaValue
-	val luaValue
+/*
+** Tables keep their elements in two parts: an array part and a hash
+** part. Non-negative integer keys are all candidates to be kept in
+** the array part. The actual size of the array is the largest 'n'
+** such that more than half the slots between 1 and n are in use.
+** Hash uses a mix of chained scatter table with Brent's variation.
+** A main invariant of these tables is that, if an element is not
+** in its main position (i.e. the 'original' position that its hash
+** gives to it), then the colliding element is in its own main
+** position. Hence even when the load factor reaches 100%,
+** performance remains good.
+ */
+// lua-5.3.4/src/ltable.c
+
+/*
+** MAXABITS is the largest integer such that MAXASIZE fits in an
+** unsigned int.
+ */
+const MAXABITS = 31
+const MAXASIZE = 1 << MAXABITS
+
+/*
+** MAXHBITS is the largest integer such that 2^MAXHBITS fits in a
+** signed int.
+ */
+const MAXHBITS = MAXABITS - 1
+
+var hashSeed = maphash.MakeSeed()
+
+type node struct {
+	key  luaValue // nil: free node; with a nil val: dead key
+	val  luaValue
+	next int // offset to next node in the collision chain (0: none)
 }
 
 type luaTable struct {
-	metatable *luaTable
-	_map      map[luaValue]luaValue
-	arr       []luaValue
-	pairs     []pair // used by next()
-	nextPairs []pair // used by next()
+	metatable  *luaTable
+	arr        []luaValue // array part
+	node       []node     // hash part, of size 2^n
+	lastFree   int        // any free position is before this index
+	weakKeys   bool       // see lua_table_weak.go
+	weakValues bool
+	toFinalize bool // marked for finalization, see lua_gc.go
 }
 
 func newLuaTable(nArr, nRec int) *luaTable {
 	t := &luaTable{}
-	if nArr > 0 {
-		t.arr = make([]luaValue, 0, nArr)
-	}
-	if nRec > 0 {
-		t._map = make(map[luaValue]luaValue, nRec)
+	if nArr > 0 || nRec > 0 {
+		t.resize(nArr, nRec)
 	}
 	return t
 }
 
 func (self *luaTable) hasMetafield(fieldName string) bool {
 	return self.metatable != nil &&
-		self.metatable.get(fieldName) != nil
+		!self.metatable.getStr(fieldName).isNil()
 }
 
-func (self *luaTable) len() int {
-	return len(self.arr)
+// calls f for each non-nil entry, in no particular order
+func (self *luaTable) forEach(f func(k, v luaValue)) {
+	for i, v := range self.arr {
+		if v = self.value(v); !v.isNil() {
+			f(intValue(int64(i+1)), v)
+		}
+	}
+	for i := range self.node {
+		if k, v := self.entry(&self.node[i]); !v.isNil() {
+			f(k, v)
+		}
+	}
 }
 
+/* main position of a key (the node its hash gives to it) */
+// lua-5.3.4/src/ltable.c#mainposition()
+func (self *luaTable) mainPosition(key luaValue) int {
+	mask := uint64(len(self.node) - 1)
+	switch key.tt {
+	case LUA_TNUMINT, LUA_TBOOLEAN:
+		return int(key.n & mask)
+	case LUA_TSTRING:
+		return int(maphash.String(hashSeed, key.asString()) & mask)
+	case LUA_TNUMFLT:
+		h := key.n
+		return int((h ^ h>>32) % (mask | 1))
+	default: /* tables, functions, userdata... */
+		return int(maphash.Comparable(hashSeed, key.o) % (mask | 1))
+	}
+}
+
+/* index of the node holding key (maybe dead), or -1 */
+func (self *luaTable) find(key luaValue) int {
+	if len(self.node) == 0 {
+		return -1
+	}
+	i := self.mainPosition(key)
+	for {
+		n := &self.node[i]
+		if n.key == key {
+			return i
+		}
+		if n.next == 0 {
+			return -1
+		}
+		i += n.next
+	}
+}
+
+// lua-5.3.4/src/ltable.c#luaH_get()
 func (self *luaTable) get(key luaValue) luaValue {
-	key = _floatToIntger(key)
-	if idx, ok := key.(int64); ok && idx >= 1 {
-		arrLen := int64(len(self.arr))
-		if idx <= arrLen {
-			return self.arr[idx-1]
+	switch key.tt {
+	case LUA_TNIL:
+		return nilValue
+	case LUA_TNUMINT:
+		return self.getInt(key.asInt())
+	case LUA_TNUMFLT:
+		if i, ok := number.FloatToInteger(key.asFloat()); ok {
+			return self.getInt(i)
 		}
 	}
-	return self._map[key]
+	if i := self.find(self.keyRef(key)); i >= 0 {
+		return self.value(self.node[i].val)
+	}
+	return nilValue
+}
+
+// lua-5.3.4/src/ltable.c#luaH_getstr()
+func (self *luaTable) getStr(key string) luaValue {
+	if i := self.find(stringValue(key)); i >= 0 {
+		return self.value(self.node[i].val)
+	}
+	return nilValue
+}
+
+// lua-5.3.4/src/ltable.c#luaH_getint()
+func (self *luaTable) getInt(key int64) luaValue {
+	if uint64(key)-1 < uint64(len(self.arr)) { /* 1 <= key <= len(arr)? */
+		return self.value(self.arr[key-1])
+	}
+	if i := self.find(intValue(key)); i >= 0 {
+		return self.value(self.node[i].val)
+	}
+	return nilValue
 }
 
 func _floatToIntger(key luaValue) luaValue {
-	if f, ok := key.(float64); ok {
-		if i, ok := number.FloatToInteger(f); ok {
-			return i
+	if key.tt == LUA_TNUMFLT {
+		if i, ok := number.FloatToInteger(key.asFloat()); ok {
+			return intValue(i)
 		}
 	}
 	return key
 }
 
 func (self *luaTable) put(key, val luaValue) {
-	if key == nil {
+	if key.isNil() {
 		panic("table index is nil!")
 	}
-	if f, ok := key.(float64); ok && math.IsNaN(f) {
+	if key.tt == LUA_TNUMFLT && math.IsNaN(key.asFloat()) {
 		panic("table index is NaN!")
 	}
+	self.set(self.keyRef(_floatToIntger(key)), self.valRef(val))
+}
 
-	self.pairs = nil // invalidate pairs
+/* key is a valid normalized key; key and val are in their stored forms */
+// lua-5.3.4/src/ltable.c#luaH_set()
+func (self *luaTable) set(key, val luaValue) {
+	if key.tt == LUA_TNUMINT && key.n-1 < uint64(len(self.arr)) {
+		self.arr[key.n-1] = val
+	} else if i := self.find(key); i >= 0 {
+		self.node[i].val = val /* dead keys are revived */
+	} else if !val.isNil() { /* absent keys need no removal */
+		self.newKey(key, val)
+	}
+}
 
-	key = _floatToIntger(key)
-	if idx, ok := key.(int64); ok && idx >= 1 {
-		arrLen := int64(len(self.arr))
-		if idx <= arrLen {
-			self.arr[idx-1] = val
-			if idx == arrLen && val == nil {
-				self._shrinkArray()
-			}
+/*
+** inserts a new key into a hash table; first, check whether key's main
+** position is free. If not, check whether colliding node is in its main
+** position or not: if it is not, move colliding node to an empty place
+** and put new key in its main position; otherwise (colliding node is in
+** its main position), new key goes to an empty position.
+ */
+// lua-5.3.4/src/ltable.c#luaH_newkey()
+func (self *luaTable) newKey(key, val luaValue) {
+	if len(self.node) == 0 { /* no hash part? */
+		self.rehash(key) /* grow table */
+		self.set(key, val)
+		return
+	}
+	mp := self.mainPosition(key)
+	if !self.node[mp].val.isNil() { /* main position is taken? */
+		/* get a free place */
+		f := self.getFreePos()
+		if f < 0 { /* cannot find a free place? */
+			self.rehash(key) /* grow table */
+			/* insert key into grown table */
+			self.set(key, val)
 			return
 		}
-		if idx == arrLen+1 {
-			delete(self._map, key)
-			if val != nil {
-				self.arr = append(self.arr, val)
-				self._expandArray()
+		nodes := self.node
+		othern := self.mainPosition(nodes[mp].key)
+		if othern != mp { /* is colliding node out of its main position? */
+			/* yes; move colliding node into free position */
+			for othern+nodes[othern].next != mp { /* find previous */
+				othern += nodes[othern].next
 			}
-			return
+			nodes[othern].next = f - othern /* rechain to point to 'f' */
+			/* copy colliding node into free pos. (mp.next also goes) */
+			nodes[f] = nodes[mp]
+			if nodes[mp].next != 0 {
+				nodes[f].next += mp - f /* correct 'next' */
+				nodes[mp].next = 0      /* now 'mp' is free */
+			}
+			nodes[mp].val = nilValue
+		} else { /* colliding node is in its own main position */
+			/* new node will go into free position */
+			if nodes[mp].next != 0 {
+				nodes[f].next = mp + nodes[mp].next - f /* chain new position */
+			}
+			nodes[mp].next = f - mp
+			mp = f
 		}
 	}
-	if val != nil {
-		if self._map == nil {
-			self._map = make(map[luaValue]luaValue, 8)
+	self.node[mp].key = key
+	self.node[mp].val = val
+}
+
+// lua-5.3.4/src/ltable.c#getfreepos()
+func (self *luaTable) getFreePos() int {
+	for self.lastFree > 0 {
+		self.lastFree--
+		if self.node[self.lastFree].key.isNil() {
+			return self.lastFree
 		}
-		self._map[key] = val
-	} else {
-		delete(self._map, key)
 	}
+	return -1 /* could not find a free place */
 }
 
-func (self *luaTable) _shrinkArray() {
-	for i := len(self.arr) - 1; i >= 0; i-- {
-		if self.arr[i] == nil {
-			self.arr = self.arr[0:i]
-		}
+/*
+** {=============================================================
+** Rehash
+** ==============================================================
+ */
+
+/* ceil(log2(x)) */
+// lua-5.3.4/src/lobject.c#luaO_ceillog2()
+func _ceilLog2(x uint) int {
+	return bits.Len(x - 1)
+}
+
+/*
+** returns the index for key if it is an appropriate key to live in
+** the array part of the table, 0 otherwise.
+ */
+// lua-5.3.4/src/ltable.c#arrayindex()
+func _arrayIndex(key luaValue) uint {
+	if i := key.asInt(); key.tt == LUA_TNUMINT && i > 0 && i <= MAXASIZE {
+		return uint(i)
 	}
+	return 0 /* 'key' did not match some condition */
 }
 
-func (self *luaTable) _expandArray() {
-	for idx := int64(len(self.arr)) + 1; true; idx++ {
-		if val, found := self._map[idx]; found {
-			delete(self._map, idx)
-			self.arr = append(self.arr, val)
-		} else {
-			break
+/*
+** Compute the optimal size for the array part of table 't'. 'nums' is a
+** "count array" where 'nums[i]' is the number of integers in the table
+** between 2^(i - 1) + 1 and 2^i. 'pna' enters with the total number of
+** integer keys in the table and leaves with the number of keys that
+** will go to the array part; return the optimal size.
+ */
+// lua-5.3.4/src/ltable.c#computesizes()
+func _computeSizes(nums []uint, pna *uint) uint {
+	var a uint = 0       /* number of elements smaller than 2^i */
+	var na uint = 0      /* number of elements to go to array part */
+	var optimal uint = 0 /* optimal size for array part */
+	/* loop while keys can fill more than half of total size */
+	for i, twotoi := 0, uint(1); i <= MAXABITS && *pna > twotoi/2; i, twotoi = i+1, twotoi*2 {
+		if nums[i] > 0 {
+			a += nums[i]
+			if a > twotoi/2 { /* more than half elements present? */
+				optimal = twotoi /* optimal size (till now) */
+				na = a           /* all elements up to 'optimal' will go to array part */
+			}
 		}
 	}
+	*pna = na
+	return optimal
 }
 
-func (self *luaTable) next(key luaValue) (nextKey, nextVal luaValue) {
-	if key == nil {
-		if self.pairs == nil {
-			self.initPairs()
+// lua-5.3.4/src/ltable.c#countint()
+func _countInt(key luaValue, nums []uint) uint {
+	if k := _arrayIndex(key); k != 0 { /* is 'key' an appropriate array index? */
+		nums[_ceilLog2(k)]++ /* count as such */
+		return 1
+	}
+	return 0
+}
+
+/*
+** Count keys in array part of table 't': Fill 'nums[i]' with
+** number of keys that will go into corresponding slice and return
+** total number of non-nil keys.
+ */
+// lua-5.3.4/src/ltable.c#numusearray()
+func (self *luaTable) numUseArray(nums []uint) uint {
+	var ause uint = 0 /* summation of 'nums' */
+	i := uint(1)      /* count to traverse all array keys */
+	size := uint(len(self.arr))
+	/* traverse each slice */
+	for lg, ttlg := 0, uint(1); lg <= MAXABITS; lg, ttlg = lg+1, ttlg*2 {
+		var lc uint = 0 /* counter */
+		lim := ttlg
+		if lim > size {
+			lim = size /* adjust upper limit */
+			if i > lim {
+				break /* no more elements to count */
+			}
+		}
+		/* count elements in range (2^(lg - 1), 2^lg] */
+		for ; i <= lim; i++ {
+			if !self.value(self.arr[i-1]).isNil() {
+				lc++
+			}
 		}
-		self.nextPairs = self.pairs
+		nums[lg] += lc
+		ause += lc
 	}
+	return ause
+}
 
-	if self.len() > 0 {
-		if key == nil {
-			return int64(1), self.arr[0]
+// lua-5.3.4/src/ltable.c#numusehash()
+func (self *luaTable) numUseHash(nums []uint, pna *uint) uint {
+	var totaluse uint = 0 /* total number of elements */
+	var ause uint = 0     /* elements added to 'nums' (can go to array part) */
+	for i := len(self.node) - 1; i >= 0; i-- {
+		if k, _ := self.entry(&self.node[i]); !k.isNil() { /* dead entries do not count */
+			ause += _countInt(k, nums)
+			totaluse++
 		}
-		if idx, ok := key.(int64); ok && idx >= 1 {
-			if idx < int64(len(self.arr)) {
-				return int64(idx + 1), self.arr[idx]
+	}
+	*pna += ause
+	return totaluse
+}
+
+/* resizes the table to fit its keys and the extra key ek */
+// lua-5.3.4/src/ltable.c#rehash()
+func (self *luaTable) rehash(ek luaValue) {
+	nums := make([]uint, MAXABITS+1)       /* counts of keys by slice */
+	na := self.numUseArray(nums)           /* count keys in array part */
+	totaluse := na                         /* all those keys are integer keys */
+	totaluse += self.numUseHash(nums, &na) /* count keys in hash part */
+	/* count extra key */
+	na += _countInt(ek, nums)
+	totaluse++
+	/* compute new size for array part */
+	asize := _computeSizes(nums, &na)
+	/* resize the table to new computed sizes */
+	self.resize(int(asize), int(totaluse-na))
+}
+
+// lua-5.3.4/src/ltable.c#setnodevector()
+func (self *luaTable) setNodeVector(size int) {
+	if size == 0 { /* no elements to hash part? */
+		self.node = nil
+		self.lastFree = 0
+		return
+	}
+	lsize := _ceilLog2(uint(size))
+	if lsize > MAXHBITS {
+		panic("table overflow")
+	}
+	size = 1 << uint(lsize)
+	self.node = make([]node, size)
+	self.lastFree = size /* all positions are free */
+}
+
+// lua-5.3.4/src/ltable.c#luaH_resize()
+func (self *luaTable) resize(nasize, nhsize int) {
+	oldArr := self.arr
+	oldNode := self.node
+	if nasize > len(oldArr) { /* array part must grow? */
+		self.arr = make([]luaValue, nasize)
+		copy(self.arr, oldArr)
+	}
+	/* create new hash part with appropriate size */
+	self.setNodeVector(nhsize)
+	if nasize < len(oldArr) { /* array part must shrink? */
+		self.arr = oldArr[:nasize:nasize]
+		/* re-insert elements from vanishing slice */
+		for i := nasize; i < len(oldArr); i++ {
+			if !self.value(oldArr[i]).isNil() {
+				self.set(intValue(int64(i+1)), oldArr[i])
 			}
 		}
+		self.arr = append([]luaValue(nil), self.arr...) /* shrink array */
+	}
+	/* re-insert elements from hash part */
+	for j := len(oldNode) - 1; j >= 0; j-- {
+		old := &oldNode[j]
+		if k, _ := self.entry(old); !k.isNil() { /* dead entries go away */
+			self.set(old.key, old.val)
+		}
 	}
+}
+
+/* }============================================================= */
+
+/*
+** returns the index of a 'key' for table traversals. First goes all
+** elements in the array part, then elements in the hash part. The
+** beginning of a traversal is signaled by 0.
+ */
+// lua-5.3.4/src/ltable.c#findindex()
+func (self *luaTable) findIndex(key luaValue) int {
+	if key.isNil() {
+		return 0 /* first iteration */
+	}
+	key = _floatToIntger(key)
+	if i := _arrayIndex(key); i != 0 && i <= uint(len(self.arr)) { /* is 'key' inside array part? */
+		return int(i) /* yes; that's the index */
+	}
+	/* key may be dead already, but it is ok to use it in 'next' */
+	if i := self.find(self.keyRef(key)); i >= 0 {
+		/* hash elements are numbered after array ones */
+		return i + 1 + len(self.arr)
+	}
+	panic("invalid key to 'next'") /* key not found */
+}
+
+// lua-5.3.4/src/ltable.c#luaH_next()
+func (self *luaTable) next(key luaValue) (nextKey, nextVal luaValue) {
+	i := self.findIndex(key)       /* find original element */
+	for ; i < len(self.arr); i++ { /* try first array part */
+		if v := self.value(self.arr[i]); !v.isNil() { /* a non-nil value? */
+			return intValue(int64(i + 1)), v
+		}
+	}
+	for i -= len(self.arr); i < len(self.node); i++ { /* hash part */
+		if k, v := self.entry(&self.node[i]); !v.isNil() { /* a live entry? */
+			return k, v
+		}
+	}
+	return nilValue, nilValue /* no more elements */
+}
 
-	if len(self.nextPairs) > 0 {
-		pair := self.nextPairs[0]
-		self.nextPairs = self.nextPairs[1:]
-		return pair.key, pair.val
-	} else {
-		return nil, nil
+/*
+** Try to find a boundary in table 't'. A 'boundary' is an integer index
+** such that t[i] is non-nil and t[i+1] is nil (and 0 if t[1] is nil).
+ */
+// lua-5.3.4/src/ltable.c#luaH_getn()
+func (self *luaTable) len() int {
+	j := uint(len(self.arr))
+	if j > 0 && self.value(self.arr[j-1]).isNil() {
+		/* there is a boundary in the array part: (binary) search for it */
+		i := uint(0)
+		for j-i > 1 {
+			m := (i + j) / 2
+			if self.value(self.arr[m-1]).isNil() {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return int(i)
+	} else if len(self.node) == 0 { /* hash part is empty? */
+		return int(j) /* that is easy... */
 	}
+	return self.unboundSearch(uint64(j))
 }
 
-func (self *luaTable) initPairs() {
-	n := len(self._map)
-	if n > 0 {
-		self.pairs = make([]pair, 0, n)
-		for key, val := range self._map {
-			self.pairs = append(self.pairs, pair{key, val})
+// lua-5.3.4/src/ltable.c#unbound_search()
+func (self *luaTable) unboundSearch(j uint64) int {
+	i := j /* i is zero or a present index */
+	j++
+	/* find 'i' and 'j' such that i is present and j is not */
+	for !self.getInt(int64(j)).isNil() {
+		i = j
+		if j > math.MaxInt64/2 { /* overflow? */
+			/* table was built with bad purposes: resort to linear search */
+			i = 1
+			for !self.getInt(int64(i)).isNil() {
+				i++
+			}
+			return int(i - 1)
+		}
+		j *= 2
+	}
+	/* now do a binary search between them */
+	for j-i > 1 {
+		m := (i + j) / 2
+		if self.getInt(int64(m)).isNil() {
+			j = m
+		} else {
+			i = m
 		}
 	}
+	return int(i)
 }
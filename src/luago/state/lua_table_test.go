@@ -0,0 +1,98 @@
+package state
+
+import "runtime"
+import "testing"
+import "assert"
+
+func TestTableParts(t *testing.T) {
+	tbl := newLuaTable(4, 2)
+	assert.IntEqual(t, len(tbl.arr), 4)
+	assert.IntEqual(t, len(tbl.node), 2)
+	assert.IntEqual(t, tbl.len(), 0)
+
+	tbl = newLuaTable(0, 0)
+	for i := int64(100); i >= 1; i-- { /* keys go first to the hash part */
+		tbl.put(intValue(i), intValue(i))
+	}
+	assert.IntEqual(t, tbl.len(), 100)
+	tbl.put(stringValue("x"), boolValue(true))
+	tbl.put(floatValue(1.5), boolValue(true))
+	assert.IntEqual(t, len(tbl.arr), 128) /* moved by rehashes */
+	assert.IntEqual(t, int(tbl.get(floatValue(2.0)).asInt()), 2)
+
+	tbl.put(intValue(50), nilValue)
+	if n := tbl.len(); n != 100 && n != 49 { /* any border */
+		t.Errorf("len: %d", n)
+	}
+}
+
+func TestTableNext(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local t = {}
+		for i = 1, 100 do t[i] = i; t["k" .. i] = i end
+		local n = 0
+		for k, v in pairs(t) do -- assigning to existing fields is allowed
+			t[k] = nil
+			if type(k) == "string" then t["k" .. (v % 10 + 1)] = nil end
+			n = n + 1
+		end
+		assert(next(t) == nil and n <= 200 and n > 100)
+
+		t = {a = 1, b = 2, c = 3}
+		local seen = ""
+		for k, v in pairs(t) do t[k] = v * 10; seen = seen .. k end
+		assert(#seen == 3 and t.a == 10 and t.b == 20 and t.c == 30)
+
+		local ok, msg = pcall(next, {}, "nokey")
+		assert(not ok and msg:find("invalid key to 'next'"))`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWeakTable(t *testing.T) {
+	weakMT := func(mode string) *luaTable {
+		mt := newLuaTable(0, 1)
+		mt.put(stringValue("__mode"), stringValue(mode))
+		return mt
+	}
+	count := func(tbl *luaTable) (n int) {
+		tbl.forEach(func(k, v luaValue) { n++ })
+		m := 0 /* next skips the same entries */
+		for k, _ := tbl.next(nilValue); !k.isNil(); k, _ = tbl.next(k) {
+			m++
+		}
+		assert.IntEqual(t, m, n)
+		return n
+	}
+
+	key := newLuaTable(0, 0)
+	keys := newLuaTable(0, 0)
+	keys.put(tableValue(key), stringValue("key"))
+	keys.put(stringValue("str"), stringValue("str"))
+	for i := 0; i < 10; i++ {
+		keys.put(tableValue(newLuaTable(0, 0)), intValue(int64(i)))
+	}
+	keys.setMetatable(weakMT("k")) /* weakens existing entries */
+	vals := newLuaTable(0, 0)
+	vals.setMetatable(weakMT("v"))
+	vals.put(intValue(1), tableValue(key))
+	for i := int64(2); i <= 8; i++ {
+		vals.put(intValue(i), tableValue(newLuaTable(0, 0)))
+	}
+	vals.put(stringValue("str"), stringValue("str"))
+
+	runtime.GC()
+	assert.IntEqual(t, count(keys), 2)
+	assert.StringEqual(t, keys.get(tableValue(key)).asString(), "key")
+	assert.IntEqual(t, count(vals), 2)
+	assert.IntEqual(t, vals.len(), 1)
+
+	keys.rehash(nilValue) /* drops dead entries */
+	assert.IntEqual(t, len(keys.node), 4)
+	assert.IntEqual(t, count(keys), 2)
+	runtime.KeepAlive(key)
+}
@@ -0,0 +1,129 @@
+package state
+
+import "strings"
+import "weak"
+import . "luago/api"
+
+/*
+** Weak tables hold weak references to the collectable keys and/or
+** values (tables, functions, userdata and threads) they store, as
+** told by the '__mode' field of their metatables. An entry whose key
+** or value was collected is dead: traversals and the length operator
+** skip it, and the next rehash drops it. Strings are values, not
+** objects, so they are never removed from weak tables.
+** For Go's collector, a value that refers to its own key keeps the
+** entry alive; full collections (lua_gc.go) handle tables with weak
+** keys as ephemeron tables, marking a value only if its key is
+** reachable, and clear the entries whose keys are not, so that Go
+** can reclaim them.
+** As with '__gc', the weakness of a table is taken from its
+** metatable when the metatable is set: adding, changing or removing
+** the '__mode' field later has no effect on the tables already
+** using the metatable until 'setmetatable' is called on them again.
+ */
+
+/* a weak reference stored in place of a collectable key or value */
+type weakRef interface {
+	strong() interface{} /* the referent, or nil if it was collected */
+}
+
+type weakPtr[T any] struct {
+	p weak.Pointer[T]
+}
+
+func (self weakPtr[T]) strong() interface{} {
+	if p := self.p.Value(); p != nil {
+		return p
+	}
+	return nil
+}
+
+/* references made from the same object compare equal */
+func _weaken(val luaValue) luaValue {
+	switch x := val.o.(type) {
+	case *luaTable:
+		val.o = weakPtr[luaTable]{weak.Make(x)}
+	case *closure:
+		val.o = weakPtr[closure]{weak.Make(x)}
+	case *userData:
+		val.o = weakPtr[userData]{weak.Make(x)}
+	case *luaState:
+		val.o = weakPtr[luaState]{weak.Make(x)}
+	} /* other values are not collectable */
+	return val
+}
+
+func _strengthen(val luaValue) luaValue {
+	if ref, ok := val.o.(weakRef); ok {
+		if val.o = ref.strong(); val.o == nil {
+			return nilValue
+		}
+	}
+	return val
+}
+
+/* the stored form of a key */
+func (self *luaTable) keyRef(key luaValue) luaValue {
+	if self.weakKeys {
+		return _weaken(key)
+	}
+	return key
+}
+
+/* the stored form of a value */
+func (self *luaTable) valRef(val luaValue) luaValue {
+	if self.weakValues {
+		return _weaken(val)
+	}
+	return val
+}
+
+/* a stored value, or nil if it was collected */
+func (self *luaTable) value(val luaValue) luaValue {
+	if self.weakValues {
+		return _strengthen(val)
+	}
+	return val
+}
+
+/* the key and value of a node, or nils if the entry is empty or dead */
+func (self *luaTable) entry(n *node) (key, val luaValue) {
+	if val = self.value(n.val); val.isNil() {
+		return nilValue, nilValue
+	}
+	if key = n.key; self.weakKeys {
+		if key = _strengthen(key); key.isNil() {
+			return nilValue, nilValue
+		}
+	}
+	return key, val
+}
+
+/* sets the metatable and picks up the weakness its '__mode' gives now */
+func (self *luaTable) setMetatable(mt *luaTable) {
+	self.metatable = mt
+	weakKeys, weakValues := false, false
+	if mt != nil {
+		if mode := mt.getStr("__mode"); mode.tt == LUA_TSTRING {
+			weakKeys = strings.IndexByte(mode.asString(), 'k') >= 0
+			weakValues = strings.IndexByte(mode.asString(), 'v') >= 0
+		}
+	}
+	if weakKeys == self.weakKeys && weakValues == self.weakValues {
+		return
+	}
+
+	/* store the live entries again in their new forms */
+	var keys, vals []luaValue
+	self.forEach(func(k, v luaValue) {
+		keys = append(keys, k)
+		vals = append(vals, v)
+	})
+	nasize := len(self.arr)
+	self.weakKeys, self.weakValues = weakKeys, weakValues
+	self.arr, self.node = nil, nil
+	self.resize(nasize, len(keys))
+	for i, k := range keys {
+		self.put(k, vals[i])
+	}
+}
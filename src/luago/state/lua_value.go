@@ -1,44 +1,167 @@
 package state
 
 import "fmt"
+import "math"
 import . "luago/api"
 import "luago/number"
 
-type luaValue interface{}
+/*
+** Values are tagged: 'tt' is the type of the value, with the variant
+** bits of numbers (LUA_TNUMINT or LUA_TNUMFLT). Integers, floats (as
+** their bits) and booleans are kept in 'n', so they need no
+** allocation; all other values are kept in 'o'. The zero value is
+** nil. Two values are the same Lua object iff they are equal with ==,
+** except for floats, which compare by their bits.
+ */
+// lua-5.3.4/src/lobject.h#TValue
+type luaValue struct {
+	tt LuaType
+	n  uint64
+	o  interface{} // string, *luaTable, *closure, *userData, lightUserData or *luaState
+}
 
-/* typeOf */
+var nilValue = luaValue{}
 
-func typeOf(val luaValue) LuaType {
-	switch val.(type) {
+func intValue(i int64) luaValue {
+	return luaValue{tt: LUA_TNUMINT, n: uint64(i)}
+}
+
+func floatValue(f float64) luaValue {
+	return luaValue{tt: LUA_TNUMFLT, n: math.Float64bits(f)}
+}
+
+func boolValue(b bool) luaValue {
+	if b {
+		return luaValue{tt: LUA_TBOOLEAN, n: 1}
+	}
+	return luaValue{tt: LUA_TBOOLEAN}
+}
+
+func stringValue(s string) luaValue {
+	return luaValue{tt: LUA_TSTRING, o: s}
+}
+
+func tableValue(t *luaTable) luaValue {
+	return luaValue{tt: LUA_TTABLE, o: t}
+}
+
+func closureValue(c *closure) luaValue {
+	return luaValue{tt: LUA_TFUNCTION, o: c}
+}
+
+func userDataValue(u *userData) luaValue {
+	return luaValue{tt: LUA_TUSERDATA, o: u}
+}
+
+func lightUserDataValue(p UserData) luaValue {
+	return luaValue{tt: LUA_TLIGHTUSERDATA, o: lightUserData{p}}
+}
+
+func threadValue(ls *luaState) luaValue {
+	return luaValue{tt: LUA_TTHREAD, o: ls}
+}
+
+/* the value of a Go value as kept by LuaError and the constant tables */
+func valueOf(x interface{}) luaValue {
+	switch y := x.(type) {
 	case nil:
-		return LUA_TNIL
+		return nilValue
 	case bool:
-		return LUA_TBOOLEAN
-	case int64, float64:
-		return LUA_TNUMBER
+		return boolValue(y)
+	case int64:
+		return intValue(y)
+	case float64:
+		return floatValue(y)
 	case string:
-		return LUA_TSTRING
+		return luaValue{tt: LUA_TSTRING, o: x}
 	case *luaTable:
-		return LUA_TTABLE
+		return luaValue{tt: LUA_TTABLE, o: x}
 	case *closure:
-		return LUA_TFUNCTION
-	case *luaState:
-		return LUA_TTHREAD
+		return luaValue{tt: LUA_TFUNCTION, o: x}
 	case *userData:
-		return LUA_TUSERDATA
+		return luaValue{tt: LUA_TUSERDATA, o: x}
+	case lightUserData:
+		return luaValue{tt: LUA_TLIGHTUSERDATA, o: x}
+	case *luaState:
+		return luaValue{tt: LUA_TTHREAD, o: x}
 	default:
-		panic("unkonwn type!")
+		panic(fmt.Sprintf("unknown type: %T", x))
 	}
 }
 
+/* the inverse of valueOf */
+func (self luaValue) toInterface() interface{} {
+	switch self.tt {
+	case LUA_TNIL:
+		return nil
+	case LUA_TBOOLEAN:
+		return self.n != 0
+	case LUA_TNUMINT:
+		return int64(self.n)
+	case LUA_TNUMFLT:
+		return math.Float64frombits(self.n)
+	default:
+		return self.o
+	}
+}
+
+func (self luaValue) isNil() bool {
+	return self.tt == LUA_TNIL
+}
+
+func (self luaValue) asBool() bool {
+	return self.n != 0
+}
+
+func (self luaValue) asInt() int64 {
+	return int64(self.n)
+}
+
+func (self luaValue) asFloat() float64 {
+	return math.Float64frombits(self.n)
+}
+
+/* the accessors below return zero values for other types */
+
+func (self luaValue) asString() string {
+	s, _ := self.o.(string)
+	return s
+}
+
+func (self luaValue) asTable() *luaTable {
+	t, _ := self.o.(*luaTable)
+	return t
+}
+
+func (self luaValue) asClosure() *closure {
+	c, _ := self.o.(*closure)
+	return c
+}
+
+func (self luaValue) asUserData() *userData {
+	u, _ := self.o.(*userData)
+	return u
+}
+
+func (self luaValue) asThread() *luaState {
+	ls, _ := self.o.(*luaState)
+	return ls
+}
+
+/* typeOf */
+
+func typeOf(val luaValue) LuaType {
+	return val.tt & 0x0F /* remove variant bits */
+}
+
 /* convert */
 
 func convertToBoolean(val luaValue) bool {
-	switch x := val.(type) {
-	case nil:
+	switch val.tt {
+	case LUA_TNIL:
 		return false
-	case bool:
-		return x
+	case LUA_TBOOLEAN:
+		return val.n != 0
 	default:
 		return true
 	}
@@ -46,13 +169,13 @@ func convertToBoolean(val luaValue) bool {
 
 // http://www.lua.org/manual/5.3/manual.html#3.4.3
 func convertToFloat(val luaValue) (float64, bool) {
-	switch x := val.(type) {
-	case int64:
-		return float64(x), true
-	case float64:
-		return x, true
-	case string:
-		return number.ParseFloat(x)
+	switch val.tt {
+	case LUA_TNUMINT:
+		return float64(val.asInt()), true
+	case LUA_TNUMFLT:
+		return val.asFloat(), true
+	case LUA_TSTRING:
+		return number.ParseFloat(val.asString())
 	default:
 		return 0, false
 	}
@@ -60,13 +183,13 @@ func convertToFloat(val luaValue) (float64, bool) {
 
 // http://www.lua.org/manual/5.3/manual.html#3.4.3
 func convertToInteger(val luaValue) (int64, bool) {
-	switch x := val.(type) {
-	case int64:
-		return x, true
-	case float64:
-		return number.FloatToInteger(x)
-	case string:
-		return _stringToInteger(x)
+	switch val.tt {
+	case LUA_TNUMINT:
+		return val.asInt(), true
+	case LUA_TNUMFLT:
+		return number.FloatToInteger(val.asFloat())
+	case LUA_TSTRING:
+		return _stringToInteger(val.asString())
 	default:
 		return 0, false
 	}
@@ -85,44 +208,47 @@ func _stringToInteger(s string) (int64, bool) {
 /* metatable */
 
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	switch x := val.(type) {
+	switch x := val.o.(type) {
 	case *luaTable:
 		return x.metatable
 	case *userData:
 		return x.metatable
 	default:
 		key := fmt.Sprintf("_MT%d", typeOf(val))
-		if mt := ls.registry.get(key); mt != nil {
-			return mt.(*luaTable)
-		}
-		return nil
+		return ls.registry.getStr(key).asTable()
 	}
 }
 
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	switch x := val.(type) {
+	switch x := val.o.(type) {
 	case *luaTable:
-		x.metatable = mt
+		x.setMetatable(mt)
+		ls.checkFinalizer(val, mt)
 	case *userData:
 		x.metatable = mt
+		ls.checkFinalizer(val, mt)
 	default:
 		key := fmt.Sprintf("_MT%d", typeOf(val))
-		ls.registry.put(key, mt)
+		if mt != nil {
+			ls.registry.put(stringValue(key), tableValue(mt))
+		} else {
+			ls.registry.put(stringValue(key), nilValue)
+		}
 	}
 }
 
 func getMetafield(val luaValue, fieldName string, ls *luaState) luaValue {
 	if mt := getMetatable(val, ls); mt != nil {
-		return mt.get(fieldName)
+		return mt.getStr(fieldName)
 	}
-	return nil
+	return nilValue
 }
 
 func callMetamethod(a, b luaValue, mmName string, ls *luaState) (luaValue, bool) {
 	var mm luaValue
-	if mm = getMetafield(a, mmName, ls); mm == nil {
-		if mm = getMetafield(b, mmName, ls); mm == nil {
-			return nil, false
+	if mm = getMetafield(a, mmName, ls); mm.isNil() {
+		if mm = getMetafield(b, mmName, ls); mm.isNil() {
+			return nilValue, false
 		}
 	}
 
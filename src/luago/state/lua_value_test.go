@@ -0,0 +1,50 @@
+package state
+
+import "testing"
+import . "luago/api"
+
+// loads chunk once and calls it b.N times
+func benchChunk(b *testing.B, chunk string) {
+	ls := New()
+	ls.OpenLibs()
+	if ls.LoadString(chunk) != LUA_OK {
+		s, _ := ls.ToString(-1)
+		b.Fatal(s)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ls.PushValue(-1)
+		ls.Call(0, 0)
+	}
+}
+
+func BenchmarkArith(b *testing.B) {
+	benchChunk(b, `
+		local x, y = 0, 0.5
+		for i = 1, 1000 do
+			x = x + i * 2 - i // 3
+			y = y * 1.0001 + i / 7
+		end`)
+}
+
+func BenchmarkTableFill(b *testing.B) {
+	benchChunk(b, `
+		local t = {}
+		for i = 1, 1000 do t[i] = i * 0.5 end
+		local h = {}
+		for i = 1, 100 do h["k" .. i % 10] = t[i] end`)
+}
+
+func BenchmarkCalls(b *testing.B) {
+	benchChunk(b, `
+		local function add(a, b) return a + b end
+		local s = 0
+		for i = 1, 1000 do s = add(s, i) end`)
+}
+
+func BenchmarkGoCalls(b *testing.B) {
+	benchChunk(b, `
+		local abs, s = math.abs, 0
+		for i = 1, 1000 do s = s + abs(-i) end`)
+}
@@ -1,8 +1,31 @@
 package state
 
-// todo
+import "fmt"
+import "reflect"
+import . "luago/api"
+
+// full userdata
 type userData struct {
-	metatable *luaTable
-	userValue luaValue
-	data      interface{} // anything
+	metatable  *luaTable
+	userValue  luaValue
+	data       interface{} // anything
+	toFinalize bool        // marked for finalization
+}
+
+// light userdata, compared by value
+type lightUserData struct {
+	p interface{}
+}
+
+func newUserData(data interface{}) *userData {
+	return &userData{data: data}
+}
+
+// light userdata are hashed and compared with ==, so they cannot hold
+// values of uncomparable types such as slices, maps and functions
+func (self *luaState) checkLightUserData(p UserData) luaValue {
+	if p != nil && !reflect.TypeOf(p).Comparable() {
+		panic(fmt.Sprintf("light userdata of uncomparable type %T", p))
+	}
+	return lightUserDataValue(p)
 }
@@ -0,0 +1,84 @@
+package state
+
+import "testing"
+import "assert"
+import . "luago/api"
+
+type point struct{ x, y int64 }
+
+func TestUserData(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	ls.NewMetatable("point")
+	ls.PushGoFunction(func(ls LuaState) int {
+		p := ls.CheckUData(1, "point").(*point)
+		switch ls.CheckString(2) {
+		case "x":
+			ls.PushInteger(p.x)
+		case "y":
+			ls.PushInteger(p.y)
+		default:
+			ls.PushNil()
+		}
+		return 1
+	})
+	ls.SetField(-2, "__index")
+	ls.PushGoFunction(func(ls LuaState) int {
+		p := ls.CheckUData(1, "point").(*point)
+		ls.PushFString("(%d,%d)", p.x, p.y)
+		return 1
+	})
+	ls.SetField(-2, "__tostring")
+	ls.Pop(1)
+
+	ls.NewUserData(&point{3, 4})
+	ls.SetMetatable2("point")
+	ls.NewTable()
+	ls.SetUserValue(-2)
+	ls.SetGlobal("p")
+
+	ls.DoString(`r = tostring(p) .. p.x + p.y .. type(p) .. type(debug.getuservalue(p))`)
+	ls.GetGlobal("r")
+	s, _ := ls.ToString(-1)
+	assert.StringEqual(t, s, "(3,4)7userdatatable")
+	ls.Pop(1)
+
+	ls.NewUserData(0)
+	if ls.TestUData(-1, "point") != nil {
+		t.Errorf("userdata without metatable")
+	}
+	ls.Pop(1)
+}
+
+func TestLightUserData(t *testing.T) {
+	ls := New()
+	key := new(int)
+
+	ls.NewTable()
+	ls.PushString("foo")
+	ls.RawSetP(-2, key)
+	ls.PushLightUserData(key)
+	if ls.Type(-1) != LUA_TLIGHTUSERDATA || !ls.IsUserData(-1) {
+		t.Errorf("light userdata expected")
+	}
+	ls.RawGet(-2)
+	s, _ := ls.ToString(-1)
+	assert.StringEqual(t, s, "foo")
+	ls.RawGetP(-2, key)
+	if !ls.RawEqual(-1, -2) || ls.ToPointer(-3) == nil {
+		t.Errorf("RawGetP failed")
+	}
+	ls.SetTop(0)
+
+	// uncomparable values raise errors instead of crashing table lookups
+	ls.PushGoFunction(func(ls LuaState) int {
+		ls.NewTable()
+		ls.PushLightUserData([]int{1})
+		ls.PushBoolean(true)
+		ls.SetTable(-3)
+		return 0
+	})
+	assert.IntEqual(t, ls.PCall(0, 0, 0), LUA_ERRRUN)
+	assert.StringEqual(t, ls.ToString2(-1), "light userdata of uncomparable type []int")
+}
@@ -230,24 +230,48 @@ func baseDoFile(ls LuaState) int {
 	if ls.LoadFile(fname) != LUA_OK {
 		return ls.Error()
 	}
-	ls.Call(0, LUA_MULTRET)
+	ls.CallK(0, LUA_MULTRET, 0, _doFileCont)
+	return _doFileCont(ls, LUA_OK, 0)
+}
+
+// lua-5.3.4/src/lbaselib.c#dofilecont()
+func _doFileCont(ls LuaState, status ThreadStatus, ctx KContext) int {
 	return ls.GetTop() - 1
 }
 
 // pcall (f [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-pcall
+// lua-5.3.4/src/lbaselib.c#luaB_pcall()
 func basePCall(ls LuaState) int {
-	nArgs := ls.GetTop() - 1
-	status := ls.PCall(nArgs, -1, 0)
-	ls.PushBoolean(status == LUA_OK)
-	ls.Insert(1)
-	return ls.GetTop()
+	ls.CheckAny(1)
+	ls.PushBoolean(true) /* first result if no errors */
+	ls.Insert(1)         /* put it in place */
+	status := ls.PCallK(ls.GetTop()-2, LUA_MULTRET, 0, 0, _finishPCall)
+	return _finishPCall(ls, status, 0)
 }
 
 // xpcall (f, msgh [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-xpcall
+// lua-5.3.4/src/lbaselib.c#luaB_xpcall()
 func baseXPCall(ls LuaState) int {
-	panic("todo! baseXPCall")
+	n := ls.GetTop()
+	ls.CheckType(2, LUA_TFUNCTION) /* check error function */
+	ls.PushBoolean(true)           /* first result */
+	ls.PushValue(1)                /* function */
+	ls.Rotate(3, 2)                /* move them below function's arguments */
+	status := ls.PCallK(n-2, LUA_MULTRET, 2, 2, _finishPCall)
+	return _finishPCall(ls, status, 2)
+}
+
+// continuation of pcall and xpcall
+// lua-5.3.4/src/lbaselib.c#finishpcall()
+func _finishPCall(ls LuaState, status ThreadStatus, extra KContext) int {
+	if status != LUA_OK && status != LUA_YIELD { /* error? */
+		ls.PushBoolean(false) /* first result (false) */
+		ls.PushValue(-2)      /* error message */
+		return 2              /* return false, msg */
+	}
+	return ls.GetTop() - extra.(int) /* return all results */
 }
 
 // getmetatable (object)
@@ -373,9 +397,26 @@ func baseToNumber(ls LuaState) int {
 	return 1
 }
 
+var gcOpts = []string{"stop", "restart", "collect",
+	"count", "step", "setpause", "setstepmul", "isrunning"}
+var gcOptsNum = []int{LUA_GCSTOP, LUA_GCRESTART, LUA_GCCOLLECT,
+	LUA_GCCOUNT, LUA_GCSTEP, LUA_GCSETPAUSE, LUA_GCSETSTEPMUL, LUA_GCISRUNNING}
+
 // collectgarbage ([opt [, arg]])
 // http://www.lua.org/manual/5.3/manual.html#pdf-collectgarbage
+// lua-5.3.4/src/lbaselib.c#luaB_collectgarbage()
 func baseCollectGarbage(ls LuaState) int {
-	// do nothing
-	return 0
+	o := gcOptsNum[ls.CheckOption(1, "collect", gcOpts)]
+	ex := int(ls.OptInteger(2, 0))
+	res := ls.GC(o, ex)
+	switch o {
+	case LUA_GCCOUNT:
+		b := ls.GC(LUA_GCCOUNTB, 0)
+		ls.PushNumber(float64(res) + float64(b)/1024)
+	case LUA_GCSTEP, LUA_GCISRUNNING:
+		ls.PushBoolean(res != 0)
+	default:
+		ls.PushInteger(int64(res))
+	}
+	return 1
 }
@@ -1,5 +1,6 @@
 package stdlib
 
+import "reflect"
 import "strings"
 import . "luago/api"
 
@@ -39,6 +40,7 @@ func dbGetInfo(ls LuaState) int {
 	arg, ls1 := _getThread(ls)
 	options := ls.OptString(arg+2, "flnStu")
 	_checkStack(ls, ls1, 3)
+	ls.ArgCheck(!strings.HasPrefix(options, ">"), arg+2, "invalid option '>'")
 	if ls.IsFunction(arg + 1) { /* info about a function? */
 		options = ">" + options /* add '>' to 'options' */
 		ls.PushString(options)
@@ -70,7 +72,9 @@ func dbGetInfo(ls LuaState) int {
 		_setTabSB(ls, "isvararg", ar.IsVararg)
 	}
 	if strings.IndexByte(options, 'n') >= 0 {
-		_setTabSS(ls, "name", ar.Name)
+		if ar.NameWhat != "" {
+			_setTabSS(ls, "name", ar.Name)
+		}
 		_setTabSS(ls, "namewhat", ar.NameWhat)
 	}
 	if strings.IndexByte(options, 't') >= 0 {
@@ -93,24 +97,211 @@ func dbGetRegistry(ls LuaState) int {
 	return 1
 }
 
+// debug.traceback ([thread,] [message [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+// lua-5.3.4/src/ldblib.c#db_traceback()
 func dbTraceback(ls LuaState) int {
-	panic("todo: dbTraceback!")
+	arg, ls1 := _getThread(ls)
+	msg, ok := ls.ToString(arg + 1)
+	if !ok && !ls.IsNoneOrNil(arg+1) { /* non-string 'msg'? */
+		ls.PushValue(arg + 1) /* return it untouched */
+	} else {
+		level := 0
+		if ls == ls1 {
+			level = 1
+		}
+		level = int(ls.OptInteger(arg+2, int64(level)))
+		ls.Traceback(ls1, msg, level)
+	}
+	return 1
+}
+
+/*
+** Key for the table that keeps hook functions
+** (hooktable[thread] = hook function)
+ */
+var _HOOKKEY = new(byte)
+
+var _hookNames = []string{"call", "return", "line", "count", "tail call"}
+
+/*
+** Call hook function registered at hook table for the current
+** thread (if there is one)
+ */
+// lua-5.3.4/src/ldblib.c#hookf()
+func _hookF(ls LuaState, ar *LuaDebug) {
+	ls.RawGetP(LUA_REGISTRYINDEX, _HOOKKEY)
+	ls.PushThread()
+	if ls.RawGet(-2) == LUA_TFUNCTION { /* is there a hook function? */
+		ls.PushString(_hookNames[ar.Event]) /* push event name */
+		if ar.CurrentLine >= 0 {
+			ls.PushInteger(int64(ar.CurrentLine)) /* push current line */
+		} else {
+			ls.PushNil()
+		}
+		ls.Call(2, 0) /* call hook function */
+	}
+}
+
+/*
+** Convert a string mask (for 'sethook') into a bit mask
+ */
+func _makeMask(smask string, count int) int {
+	mask := 0
+	if strings.IndexByte(smask, 'c') >= 0 {
+		mask |= LUA_MASKCALL
+	}
+	if strings.IndexByte(smask, 'r') >= 0 {
+		mask |= LUA_MASKRET
+	}
+	if strings.IndexByte(smask, 'l') >= 0 {
+		mask |= LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= LUA_MASKCOUNT
+	}
+	return mask
+}
+
+/*
+** Convert a bit mask (for 'gethook') into a string mask
+ */
+func _unmakeMask(mask int) string {
+	smask := ""
+	if mask&LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+func _isHookF(hook LuaHook) bool {
+	return reflect.ValueOf(hook).Pointer() == reflect.ValueOf(_hookF).Pointer()
 }
 
+// debug.gethook ([thread])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+// lua-5.3.4/src/ldblib.c#db_gethook()
 func dbGetHook(ls LuaState) int {
-	panic("todo: dbGetHook!")
+	_, ls1 := _getThread(ls)
+	mask := ls1.GetHookMask()
+	hook := ls1.GetHook()
+	if hook == nil { /* no hook? */
+		ls.PushNil()
+	} else if !_isHookF(hook) { /* external hook? */
+		ls.PushString("external hook")
+	} else { /* hook table must exist */
+		ls.RawGetP(LUA_REGISTRYINDEX, _HOOKKEY)
+		_checkStack(ls, ls1, 1)
+		ls1.PushThread()
+		ls1.XMove(ls, 1)
+		ls.RawGet(-2) /* 1st result = hooktable[L1] */
+		ls.Remove(-2) /* remove hook table */
+	}
+	ls.PushString(_unmakeMask(mask))          /* 2nd result = mask */
+	ls.PushInteger(int64(ls1.GetHookCount())) /* 3rd result = count */
+	return 3
 }
 
+// debug.sethook ([thread,] hook, mask [, count])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+// lua-5.3.4/src/ldblib.c#db_sethook()
 func dbSetHook(ls LuaState) int {
-	panic("todo: dbSetHook!")
+	var mask, count int
+	var f LuaHook
+	arg, ls1 := _getThread(ls)
+	if ls.IsNoneOrNil(arg + 1) { /* no hook? */
+		ls.SetTop(arg + 1)
+		f, mask, count = nil, 0, 0 /* turn off hooks */
+	} else {
+		smask := ls.CheckString(arg + 2)
+		ls.CheckType(arg+1, LUA_TFUNCTION)
+		count = int(ls.OptInteger(arg+3, 0))
+		f, mask = _hookF, _makeMask(smask, count)
+	}
+	if ls.RawGetP(LUA_REGISTRYINDEX, _HOOKKEY) == LUA_TNIL {
+		ls.CreateTable(0, 2) /* create a hook table */
+		ls.PushValue(-1)
+		ls.RawSetP(LUA_REGISTRYINDEX, _HOOKKEY) /* set it in position */
+		ls.PushString("k")
+		ls.SetField(-2, "__mode") /** hooktable.__mode = "k" */
+		ls.PushValue(-1)
+		ls.SetMetatable(-2) /* setmetatable(hooktable) = hooktable */
+	}
+	_checkStack(ls, ls1, 1)
+	ls1.PushThread()
+	ls1.XMove(ls, 1)      /* key (thread) */
+	ls.PushValue(arg + 1) /* value (hook function) */
+	ls.RawSet(-3)         /* hooktable[L1] = new Lua hook */
+	ls1.SetHook(f, mask, count)
+	return 0
 }
 
+// debug.getlocal ([thread,] f, local)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+// lua-5.3.4/src/ldblib.c#db_getlocal()
 func dbGetLocal(ls LuaState) int {
-	panic("todo: dbGetLocal!")
+	arg, ls1 := _getThread(ls)
+	ar := &LuaDebug{}
+	nvar := int(ls.CheckInteger(arg + 2)) /* local-variable index */
+	if ls.IsFunction(arg + 1) {           /* function argument? */
+		ls.PushValue(arg + 1)                 /* push function */
+		_pushName(ls, ls.GetLocal(nil, nvar)) /* push local name */
+		return 1                              /* return only name (there is no value) */
+	} else { /* stack-level argument */
+		level := int(ls.CheckInteger(arg + 1))
+		if !ls1.GetStack(level, ar) { /* out of range? */
+			return ls.ArgError(arg+1, "level out of range")
+		}
+		_checkStack(ls, ls1, 1)
+		name := ls1.GetLocal(ar, nvar)
+		if name != "" {
+			ls1.XMove(ls, 1)    /* move local value */
+			ls.PushString(name) /* push name */
+			ls.Rotate(-2, 1)    /* re-order */
+			return 2
+		} else {
+			ls.PushNil() /* no name (nor value) */
+			return 1
+		}
+	}
 }
 
+// debug.setlocal ([thread,] level, local, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setlocal
+// lua-5.3.4/src/ldblib.c#db_setlocal()
 func dbSetLocal(ls LuaState) int {
-	panic("todo: dbSetLocal!")
+	arg, ls1 := _getThread(ls)
+	ar := &LuaDebug{}
+	level := int(ls.CheckInteger(arg + 1))
+	nvar := int(ls.CheckInteger(arg + 2))
+	if !ls1.GetStack(level, ar) { /* out of range? */
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	ls.CheckAny(arg + 3)
+	ls.SetTop(arg + 3)
+	_checkStack(ls, ls1, 1)
+	ls.XMove(ls1, 1)
+	name := ls1.SetLocal(ar, nvar)
+	if name == "" {
+		ls1.Pop(1) /* pop value (if not popped by 'lua_setlocal') */
+	}
+	_pushName(ls, name)
+	return 1
+}
+
+// pushes name, or nil if it is empty
+func _pushName(ls LuaState, name string) {
+	if name == "" {
+		ls.PushNil()
+	} else {
+		ls.PushString(name)
+	}
 }
 
 // debug.getmetatable (value)
@@ -168,20 +359,63 @@ func _auxUpvalue(ls LuaState, get int) int {
 	return get + 1
 }
 
+/*
+** Check whether a given upvalue from a given closure exists and
+** returns its index
+ */
+// lua-5.3.4/src/ldblib.c#checkupval()
+func _checkUpval(ls LuaState, argf, argnup int) int {
+	nup := int(ls.CheckInteger(argnup)) /* upvalue index */
+	ls.CheckType(argf, LUA_TFUNCTION)   /* closure */
+	ar := &LuaDebug{}
+	ls.PushValue(argf)
+	ls.GetInfo(">u", ar)
+	ls.ArgCheck(1 <= nup && nup <= ar.NUps, argnup, "invalid upvalue index")
+	return nup
+}
+
+// debug.upvalueid (f, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvalueid
+// lua-5.3.4/src/ldblib.c#db_upvalueid()
 func dbUpvalueId(ls LuaState) int {
-	panic("todo: dbUpvalueId!")
+	n := _checkUpval(ls, 1, 2)
+	ls.PushLightUserData(ls.UpvalueId(1, n))
+	return 1
 }
 
+// debug.upvaluejoin (f1, n1, f2, n2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvaluejoin
+// lua-5.3.4/src/ldblib.c#db_upvaluejoin()
 func dbUpvalueJoin(ls LuaState) int {
-	panic("todo: dbUpvalueJoin!")
+	ls.ArgCheck(!ls.IsGoFunction(1), 1, "Lua function expected")
+	ls.ArgCheck(!ls.IsGoFunction(3), 3, "Lua function expected")
+	n1 := _checkUpval(ls, 1, 2)
+	n2 := _checkUpval(ls, 3, 4)
+	ls.UpvalueJoin(1, n1, 3, n2)
+	return 0
 }
 
+// debug.setuservalue (udata, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setuservalue
+// lua-5.3.4/src/ldblib.c#db_setuservalue()
 func dbSetUserValue(ls LuaState) int {
-	panic("todo: dbSetUserValue!")
+	ls.CheckType(1, LUA_TUSERDATA)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.SetUserValue(1)
+	return 1
 }
 
+// debug.getuservalue (u)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getuservalue
+// lua-5.3.4/src/ldblib.c#db_getuservalue()
 func dbGetUserValue(ls LuaState) int {
-	panic("todo: dbGetUserValue!")
+	if ls.Type(1) != LUA_TUSERDATA {
+		ls.PushNil()
+	} else {
+		ls.GetUserValue(1)
+	}
+	return 1
 }
 
 /*
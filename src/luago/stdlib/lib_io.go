@@ -1,7 +1,51 @@
 package stdlib
 
+import "bufio"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+import "os/exec"
+import "strings"
+import "syscall"
 import . "luago/api"
 
+/*
+** Files are full userdata with the metatable 'FILE*'. Their '__gc'
+** metamethod closes them, so closing the state closes all the files
+** that were opened by this library and are still open.
+ */
+
+// lua-5.3.4/src/lauxlib.h#LUA_FILEHANDLE
+const LUA_FILEHANDLE = "FILE*"
+
+const (
+	_IO_PREFIX = "_IO_"
+	_IO_INPUT  = _IO_PREFIX + "input"
+	_IO_OUTPUT = _IO_PREFIX + "output"
+)
+
+/* maximum number of arguments to 'f:lines'/'io.lines' */
+const _MAXARGLINE = 250
+
+/* maximum length of a numeral */
+const _L_MAXLENNUM = 200
+
+/*
+** A file handle: reads and writes are buffered, and a stream that
+** is both read and written drops its read-ahead data before writing.
+ */
+// lua-5.3.4/src/lauxlib.h#luaL_Stream
+type luaStream struct {
+	file   *os.File      /* nil for pipes */
+	r      *bufio.Reader /* nil if the stream cannot be read */
+	w      *bufio.Writer /* nil if the stream cannot be written */
+	vbuf   string        /* buffering mode of writes: "no", "full" or "line" */
+	cmd    *exec.Cmd     /* process of a pipe */
+	stdin  io.Closer     /* its input, for "w" pipes */
+	closef GoFunction    /* to close stream (nil for closed streams) */
+}
+
 /* functions for 'io' library */
 var ioLib = map[string]GoFunction{
 	"close":   ioClose,
@@ -17,56 +61,809 @@ var ioLib = map[string]GoFunction{
 	"write":   ioWrite,
 }
 
+/* methods for file handles */
+var fileLib = map[string]GoFunction{
+	"close":      ioClose,
+	"flush":      fFlush,
+	"lines":      fLines,
+	"read":       fRead,
+	"seek":       fSeek,
+	"setvbuf":    fSetVBuf,
+	"write":      fWrite,
+	"__gc":       fGC,
+	"__tostring": fToString,
+}
+
+// lua-5.3.4/src/liolib.c#luaopen_io()
 func OpenIOLib(ls LuaState) int {
 	ls.NewLib(ioLib) /* new module */
-	//createmeta(L);
+	_createMeta(ls)
 	/* create (and set) default files */
-	//createstdfile(L, stdin, IO_INPUT, "stdin");
-	//createstdfile(L, stdout, IO_OUTPUT, "stdout");
-	//createstdfile(L, stderr, NULL, "stderr");
+	_createStdFile(ls, os.Stdin, _IO_INPUT, "stdin")
+	_createStdFile(ls, os.Stdout, _IO_OUTPUT, "stdout")
+	_createStdFile(ls, os.Stderr, "", "stderr")
 	return 1
 }
 
+// lua-5.3.4/src/liolib.c#createmeta()
+func _createMeta(ls LuaState) {
+	ls.NewMetatable(LUA_FILEHANDLE) /* create metatable for file handles */
+	ls.PushValue(-1)                /* push metatable */
+	ls.SetField(-2, "__index")      /* metatable.__index = metatable */
+	ls.SetFuncs(fileLib, 0)         /* add file methods to new metatable */
+	ls.Pop(1)                       /* pop new metatable */
+}
+
+// lua-5.3.4/src/liolib.c#createstdfile()
+func _createStdFile(ls LuaState, f *os.File, k, fname string) {
+	p := _newPreFile(ls)
+	p.file = f
+	if f == os.Stdin {
+		p.r = bufio.NewReader(f)
+	} else {
+		p.w = bufio.NewWriter(f)
+		p.vbuf = "no" /* keep the order of 'print' and 'io.write' */
+	}
+	p.closef = _ioNoClose
+	if k != "" {
+		ls.PushValue(-1)
+		ls.SetField(LUA_REGISTRYINDEX, k) /* add file to registry */
+	}
+	ls.SetField(-2, fname) /* add file to module */
+}
+
+/*
+** When creating file handles, always creates a 'closed' file handle
+** before opening the actual file; so, if there is a memory error, the
+** handle is in a consistent state.
+ */
+// lua-5.3.4/src/liolib.c#newprefile()
+func _newPreFile(ls LuaState) *luaStream {
+	p := &luaStream{vbuf: "full"} /* mark file handle as 'closed' */
+	ls.NewUserData(p)
+	ls.SetMetatable2(LUA_FILEHANDLE)
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#newfile()
+func _newFile(ls LuaState) *luaStream {
+	p := _newPreFile(ls)
+	p.closef = _ioFClose
+	return p
+}
+
+func _toLStream(ls LuaState) *luaStream {
+	return ls.CheckUData(1, LUA_FILEHANDLE).(*luaStream)
+}
+
+// lua-5.3.4/src/liolib.c#tofile()
+func _toFile(ls LuaState) *luaStream {
+	p := _toLStream(ls)
+	if p.closef == nil {
+		ls.Error2("attempt to use a closed file")
+	}
+	return p
+}
+
+/*
+** Calls the 'close' function from a file handle, at index 1. The
+** handle is marked as closed before the call, so a failed close
+** leaves a closed handle.
+ */
+// lua-5.3.4/src/liolib.c#aux_close()
+func _auxClose(ls LuaState) int {
+	p := _toLStream(ls)
+	cf := p.closef
+	p.closef = nil /* mark stream as closed */
+	return cf(ls)  /* close it */
+}
+
+/* function to close regular files */
+// lua-5.3.4/src/liolib.c#io_fclose()
+func _ioFClose(ls LuaState) int {
+	p := _toLStream(ls)
+	err := p.flush()
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	return _fileResult(ls, err, "")
+}
+
+/* function to (not) close the standard files stdin, stdout, and stderr */
+// lua-5.3.4/src/liolib.c#io_noclose()
+func _ioNoClose(ls LuaState) int {
+	p := _toLStream(ls)
+	p.closef = _ioNoClose /* keep file opened */
+	ls.PushNil()
+	ls.PushString("cannot close standard file")
+	return 2
+}
+
+/* function to close 'popen' files */
+// lua-5.3.4/src/liolib.c#io_pclose()
+func _ioPClose(ls LuaState) int {
+	p := _toLStream(ls)
+	err := p.flush()
+	if p.stdin != nil { /* let the process see the end of its input */
+		p.stdin.Close()
+	}
+	if err == nil {
+		err = p.cmd.Wait()
+	}
+	return _execResult(ls, err)
+}
+
+// io.close ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.close
+// lua-5.3.4/src/liolib.c#io_close()
 func ioClose(ls LuaState) int {
-	panic("todo: ioClose!")
+	if ls.IsNone(1) { /* no argument? */
+		ls.GetField(LUA_REGISTRYINDEX, _IO_OUTPUT) /* use standard output */
+	}
+	_toFile(ls) /* make sure argument is an open stream */
+	return _auxClose(ls)
 }
 
-func ioFlush(ls LuaState) int {
-	panic("todo: ioFlush!")
+// lua-5.3.4/src/liolib.c#f_gc()
+func fGC(ls LuaState) int {
+	if p := _toLStream(ls); p.closef != nil && (p.r != nil || p.w != nil) {
+		_auxClose(ls) /* ignore closed and incompletely open files */
+	}
+	return 0
 }
 
-func ioInput(ls LuaState) int {
-	panic("todo: ioInput!")
+// lua-5.3.4/src/liolib.c#f_tostring()
+func fToString(ls LuaState) int {
+	if p := _toLStream(ls); p.closef == nil {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", p))
+	}
+	return 1
 }
 
-func ioLines(ls LuaState) int {
-	panic("todo: ioLines!")
+/* checks whether 'mode' matches '[rwa]%+?b*' */
+// lua-5.3.4/src/liolib.c#l_checkmode()
+func _checkMode(mode string) bool {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return false
+	}
+	mode = strings.TrimPrefix(mode[1:], "+")
+	return strings.Trim(mode, "b") == ""
 }
 
+/* the flags of os.OpenFile for a valid mode */
+func _openFlags(mode string) int {
+	flags := 0
+	switch mode[0] {
+	case 'r':
+		flags = os.O_RDONLY
+	case 'w':
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case 'a':
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	if strings.IndexByte(mode, '+') >= 0 {
+		flags = flags&^os.O_WRONLY | os.O_RDWR
+	}
+	return flags
+}
+
+// lua-5.3.4/src/liolib.c#opencheckfile()
+func _openCheckFile(ls LuaState, fname, mode string) {
+	p := _newFile(ls)
+	if err := p.open(fname, mode); err != nil {
+		ls.Error2("cannot open file '%s' (%s)", fname, _errorString(err))
+	}
+}
+
+// io.open (filename [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.open
+// lua-5.3.4/src/liolib.c#io_open()
 func ioOpen(ls LuaState) int {
-	panic("todo: ioOpen!")
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	p := _newFile(ls)
+	ls.ArgCheck(_checkMode(mode), 2, "invalid mode")
+	if err := p.open(filename, mode); err != nil {
+		return _fileResult(ls, err, filename)
+	}
+	return 1
+}
+
+// io.popen (prog [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.popen
+// lua-5.3.4/src/liolib.c#io_popen()
+func ioPopen(ls LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	p := _newPreFile(ls)
+	ls.ArgCheck(mode == "r" || mode == "w", 2, "invalid mode")
+	p.cmd = exec.Command("/bin/sh", "-c", filename)
+	p.cmd.Stderr = os.Stderr
+	var err error
+	if mode == "r" {
+		p.cmd.Stdin = os.Stdin
+		var out io.ReadCloser
+		if out, err = p.cmd.StdoutPipe(); err == nil {
+			p.r = bufio.NewReader(out)
+		}
+	} else {
+		p.cmd.Stdout = os.Stdout
+		var in io.WriteCloser
+		if in, err = p.cmd.StdinPipe(); err == nil {
+			p.w = bufio.NewWriter(in)
+			p.stdin = in
+		}
+	}
+	if err == nil {
+		err = p.cmd.Start()
+	}
+	if err != nil {
+		return _fileResult(ls, err, filename)
+	}
+	p.closef = _ioPClose
+	return 1
+}
+
+// io.tmpfile ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.tmpfile
+// lua-5.3.4/src/liolib.c#io_tmpfile()
+func ioTmpFile(ls LuaState) int {
+	p := _newFile(ls)
+	f, err := os.CreateTemp("", "lua_")
+	if err != nil {
+		return _fileResult(ls, err, "")
+	}
+	os.Remove(f.Name()) /* removed when closed */
+	p.setFile(f, true, true)
+	return 1
+}
+
+// io.type (obj)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.type
+// lua-5.3.4/src/liolib.c#io_type()
+func ioType(ls LuaState) int {
+	ls.CheckAny(1)
+	if p, _ := ls.TestUData(1, LUA_FILEHANDLE).(*luaStream); p == nil {
+		ls.PushNil() /* not a file */
+	} else if p.closef == nil {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#getiofile()
+func _getIOFile(ls LuaState, findex string) *luaStream {
+	ls.GetField(LUA_REGISTRYINDEX, findex)
+	p := ls.ToUserData(-1).(*luaStream)
+	if p.closef == nil {
+		ls.Error2("standard %s file is closed", findex[len(_IO_PREFIX):])
+	}
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#g_iofile()
+func _gIOFile(ls LuaState, f, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if filename, ok := ls.ToString(1); ok {
+			_openCheckFile(ls, filename, mode)
+		} else {
+			_toFile(ls) /* check that it's a valid file handle */
+			ls.PushValue(1)
+		}
+		ls.SetField(LUA_REGISTRYINDEX, f)
+	}
+	/* return current value */
+	ls.GetField(LUA_REGISTRYINDEX, f)
+	return 1
+}
+
+// io.input ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.input
+// lua-5.3.4/src/liolib.c#io_input()
+func ioInput(ls LuaState) int {
+	return _gIOFile(ls, _IO_INPUT, "r")
 }
 
+// io.output ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.output
+// lua-5.3.4/src/liolib.c#io_output()
 func ioOutput(ls LuaState) int {
-	panic("todo: ioOutput!")
+	return _gIOFile(ls, _IO_OUTPUT, "w")
 }
 
-func ioPopen(ls LuaState) int {
-	panic("todo: ioPopen!")
+/*
+** the iterator keeps as upvalues the file, the number of formats,
+** whether to close the file when finished and the formats
+ */
+// lua-5.3.4/src/liolib.c#aux_lines()
+func _auxLines(ls LuaState, toClose bool) {
+	n := ls.GetTop() - 1 /* number of arguments to read */
+	ls.ArgCheck(n <= _MAXARGLINE, _MAXARGLINE+2, "too many arguments")
+	ls.PushInteger(int64(n)) /* number of arguments to read */
+	ls.PushBoolean(toClose)  /* close/not close file when finished */
+	ls.Rotate(2, 2)          /* move 'n' and 'toclose' to their positions */
+	ls.PushGoClosure(ioReadLine, 3+n)
+}
+
+// file:lines (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:lines
+// lua-5.3.4/src/liolib.c#f_lines()
+func fLines(ls LuaState) int {
+	_toFile(ls) /* check that it's a valid file handle */
+	_auxLines(ls, false)
+	return 1
+}
+
+// io.lines ([filename, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.lines
+// lua-5.3.4/src/liolib.c#io_lines()
+func ioLines(ls LuaState) int {
+	toClose := false
+	if ls.IsNone(1) {
+		ls.PushNil() /* at least one argument */
+	}
+	if ls.IsNil(1) { /* no file name? */
+		ls.GetField(LUA_REGISTRYINDEX, _IO_INPUT) /* get default input */
+		ls.Replace(1)                             /* put it at index 1 */
+		_toFile(ls)                               /* check that it's a valid file handle */
+	} else { /* open a new file */
+		filename := ls.CheckString(1)
+		_openCheckFile(ls, filename, "r")
+		ls.Replace(1)  /* put file at index 1 */
+		toClose = true /* close it after iteration */
+	}
+	_auxLines(ls, toClose)
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#io_readline()
+func ioReadLine(ls LuaState) int {
+	p := ls.ToUserData(LuaUpvalueIndex(1)).(*luaStream)
+	n := int(ls.ToInteger(LuaUpvalueIndex(2)))
+	if p.closef == nil { /* file is already closed? */
+		return ls.Error2("file is already closed")
+	}
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { /* push arguments to 'g_read' */
+		ls.PushValue(LuaUpvalueIndex(3 + i))
+	}
+	n = _gRead(ls, p, 2)  /* 'n' is number of results */
+	if ls.ToBoolean(-n) { /* read at least one value? */
+		return n /* return them */
+	}
+	/* first result is nil: EOF or error */
+	if n > 1 { /* is there error information? */
+		/* 2nd result is error message */
+		return ls.Error2("%s", ls.ToString2(-n+1))
+	}
+	if ls.ToBoolean(LuaUpvalueIndex(3)) { /* generate error? */
+		ls.SetTop(0)
+		ls.PushValue(LuaUpvalueIndex(1))
+		_auxClose(ls) /* close it */
+	}
+	return 0
+}
+
+/* reads a numeral, as PUC Lua does, ignoring the locale */
+// lua-5.3.4/src/liolib.c#RN
+type _rn struct {
+	r    *bufio.Reader
+	c    int    /* current character (look ahead), -1 at EOF */
+	buff []byte /* up to _L_MAXLENNUM characters */
+	bad  bool   /* numeral too long */
+}
+
+func (self *_rn) getc() {
+	if b, err := self.r.ReadByte(); err == nil {
+		self.c = int(b)
+	} else {
+		self.c = -1
+	}
+}
+
+/* adds current char to buffer (if not out of space) and reads next one */
+// lua-5.3.4/src/liolib.c#nextc()
+func (self *_rn) nextc() bool {
+	if len(self.buff) >= _L_MAXLENNUM { /* buffer overflow? */
+		self.bad = true /* invalidate result */
+		return false    /* fail */
+	}
+	self.buff = append(self.buff, byte(self.c)) /* save current char */
+	self.getc()                                 /* read next one */
+	return true
+}
+
+/* accepts current char if it is in 'set' (of size 2) */
+// lua-5.3.4/src/liolib.c#test2()
+func (self *_rn) test2(set string) bool {
+	if self.c == int(set[0]) || self.c == int(set[1]) {
+		return self.nextc()
+	}
+	return false
+}
+
+/* reads a sequence of (hex)digits */
+// lua-5.3.4/src/liolib.c#readdigits()
+func (self *_rn) readDigits(hex bool) int {
+	count := 0
+	for self.c >= 0 && _isDigit(byte(self.c), hex) && self.nextc() {
+		count++
+	}
+	return count
+}
+
+func _isSpace(c byte) bool {
+	return strings.IndexByte(" \f\n\r\t\v", c) >= 0
+}
+
+func _isDigit(c byte, hex bool) bool {
+	if '0' <= c && c <= '9' {
+		return true
+	}
+	return hex && ('a' <= c && c <= 'f' || 'A' <= c && c <= 'F')
 }
 
+/*
+** Reads a number: first reads a valid prefix of a numeral into a
+** buffer. Then it calls 'StringToNumber' to check whether the format
+** is correct and to convert it to a Lua number.
+ */
+// lua-5.3.4/src/liolib.c#read_number()
+func _readNumber(ls LuaState, r *bufio.Reader) bool {
+	rn := &_rn{r: r}
+	count := 0
+	hex := false
+	for rn.getc(); rn.c >= 0 && _isSpace(byte(rn.c)); { /* skip spaces */
+		rn.getc()
+	}
+	rn.test2("-+")      /* optional signal */
+	if rn.test2("00") { /* (optional) '0'? */
+		if rn.test2("xX") {
+			hex = true /* numeral is hexadecimal */
+		} else {
+			count = 1 /* count initial '0' as a valid digit */
+		}
+	}
+	count += rn.readDigits(hex) /* integral part */
+	if rn.test2("..") {         /* decimal point? */
+		count += rn.readDigits(hex) /* fractional part */
+	}
+	expo := "eE"
+	if hex {
+		expo = "pP"
+	}
+	if count > 0 && rn.test2(expo) { /* exponent mark? */
+		rn.test2("-+")       /* exponent signal */
+		rn.readDigits(false) /* exponent digits */
+	}
+	if rn.c >= 0 {
+		r.UnreadByte() /* unread look-ahead char */
+	}
+	if !rn.bad && ls.StringToNumber(string(rn.buff)) {
+		return true /* ok */
+	}
+	/* invalid format */
+	ls.PushNil() /* "result" to be removed */
+	return false /* read fails */
+}
+
+// lua-5.3.4/src/liolib.c#test_eof()
+func _testEOF(ls LuaState, r *bufio.Reader) (bool, error) {
+	_, err := r.Peek(1)
+	ls.PushString("")
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lua-5.3.4/src/liolib.c#read_line()
+func _readLine(ls LuaState, r *bufio.Reader, chop bool) (bool, error) {
+	line, err := r.ReadString('\n')
+	ok := err == nil || line != "" /* read a line or something */
+	if chop && err == nil {
+		line = line[:len(line)-1] /* remove '\n' */
+	}
+	ls.PushString(line)
+	if err == io.EOF {
+		err = nil
+	}
+	return ok, err
+}
+
+// lua-5.3.4/src/liolib.c#read_all()
+func _readAll(ls LuaState, r *bufio.Reader) error {
+	data, err := io.ReadAll(r)
+	ls.PushString(string(data))
+	return err
+}
+
+// lua-5.3.4/src/liolib.c#read_chars()
+func _readChars(ls LuaState, r *bufio.Reader, n int64) (bool, error) {
+	data, err := io.ReadAll(io.LimitReader(r, n))
+	ls.PushString(string(data))
+	return len(data) > 0, err /* true iff read something */
+}
+
+// lua-5.3.4/src/liolib.c#g_read()
+func _gRead(ls LuaState, p *luaStream, first int) int {
+	nargs := ls.GetTop() - 1
+	err := p.reading()
+	success := true
+	n := first
+	if err != nil {
+		/* fall through to report the error */
+	} else if nargs == 0 { /* no arguments? */
+		success, err = _readLine(ls, p.r, true)
+		n = first + 1 /* to return 1 result */
+	} else { /* ensure stack space for all results and for auxlib's buffer */
+		ls.CheckStack2(nargs+LUA_MINSTACK, "too many arguments")
+		for n = first; nargs > 0 && success && err == nil; n++ {
+			nargs--
+			if ls.Type(n) == LUA_TNUMBER {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success, err = _testEOF(ls, p.r)
+				} else {
+					success, err = _readChars(ls, p.r, l)
+				}
+			} else {
+				f := strings.TrimPrefix(ls.CheckString(n), "*") /* skip optional '*' (for compatibility) */
+				switch {
+				case strings.HasPrefix(f, "n"): /* number */
+					success = _readNumber(ls, p.r)
+				case strings.HasPrefix(f, "l"): /* line */
+					success, err = _readLine(ls, p.r, true)
+				case strings.HasPrefix(f, "L"): /* line with end-of-line */
+					success, err = _readLine(ls, p.r, false)
+				case strings.HasPrefix(f, "a"): /* file */
+					err = _readAll(ls, p.r) /* read entire file */
+					success = true          /* always success */
+				default:
+					return ls.ArgError(n, "invalid format")
+				}
+			}
+		}
+	}
+	if err != nil {
+		return _fileResult(ls, err, "")
+	}
+	if !success {
+		ls.Pop(1)    /* remove last result */
+		ls.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+// io.read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.read
+// lua-5.3.4/src/liolib.c#io_read()
 func ioRead(ls LuaState) int {
-	panic("todo: ioRead!")
+	return _gRead(ls, _getIOFile(ls, _IO_INPUT), 1)
 }
 
-func ioTmpFile(ls LuaState) int {
-	panic("todo: ioTmpFile!")
+// file:read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:read
+// lua-5.3.4/src/liolib.c#f_read()
+func fRead(ls LuaState) int {
+	return _gRead(ls, _toFile(ls), 2)
 }
 
-func ioType(ls LuaState) int {
-	panic("todo: ioType!")
+// lua-5.3.4/src/liolib.c#g_write()
+func _gWrite(ls LuaState, p *luaStream, arg int) int {
+	nargs := ls.GetTop() - arg
+	err := p.writing()
+	for ; nargs > 0 && err == nil; nargs-- {
+		var s string
+		if ls.Type(arg) == LUA_TNUMBER {
+			if ls.IsInteger(arg) {
+				s = fmt.Sprintf("%d", ls.ToInteger(arg))
+			} else {
+				s = fmt.Sprintf("%.14g", ls.ToNumber(arg))
+			}
+		} else {
+			s = ls.CheckString(arg)
+		}
+		err = p.write(s)
+		arg++
+	}
+	if err == nil {
+		return 1 /* file handle already on stack top */
+	}
+	return _fileResult(ls, err, "")
 }
 
+// io.write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.write
+// lua-5.3.4/src/liolib.c#io_write()
 func ioWrite(ls LuaState) int {
-	panic("todo: ioWrite!")
+	return _gWrite(ls, _getIOFile(ls, _IO_OUTPUT), 1)
+}
+
+// file:write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:write
+// lua-5.3.4/src/liolib.c#f_write()
+func fWrite(ls LuaState) int {
+	p := _toFile(ls)
+	ls.PushValue(1) /* push file at the stack top (to be returned) */
+	return _gWrite(ls, p, 2)
+}
+
+// file:seek ([whence [, offset]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:seek
+// lua-5.3.4/src/liolib.c#f_seek()
+func fSeek(ls LuaState) int {
+	p := _toFile(ls)
+	op := ls.CheckOption(2, "cur", []string{"set", "cur", "end"})
+	offset := ls.OptInteger(3, 0)
+	pos, err := p.seek(offset, op)
+	if err != nil {
+		return _fileResult(ls, err, "") /* error */
+	}
+	ls.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:setvbuf
+// lua-5.3.4/src/liolib.c#f_setvbuf()
+func fSetVBuf(ls LuaState) int {
+	modeNames := []string{"no", "full", "line"}
+	p := _toFile(ls)
+	op := ls.CheckOption(2, "", modeNames)
+	ls.OptInteger(3, 0) /* the size is ignored */
+	err := p.flush()
+	p.vbuf = modeNames[op]
+	return _fileResult(ls, err, "")
+}
+
+// io.flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.flush
+// lua-5.3.4/src/liolib.c#io_flush()
+func ioFlush(ls LuaState) int {
+	return _fileResult(ls, _getIOFile(ls, _IO_OUTPUT).flush(), "")
+}
+
+// file:flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:flush
+// lua-5.3.4/src/liolib.c#f_flush()
+func fFlush(ls LuaState) int {
+	return _fileResult(ls, _toFile(ls).flush(), "")
+}
+
+/* streams */
+
+func (self *luaStream) open(name, mode string) error {
+	flags := _openFlags(mode)
+	f, err := os.OpenFile(name, flags, 0666)
+	if err == nil {
+		self.setFile(f, flags&os.O_WRONLY == 0, flags&(os.O_WRONLY|os.O_RDWR) != 0)
+	}
+	return err
+}
+
+func (self *luaStream) setFile(f *os.File, readable, writable bool) {
+	self.file = f
+	if readable {
+		self.r = bufio.NewReader(f)
+	}
+	if writable {
+		self.w = bufio.NewWriter(f)
+	}
+}
+
+/* flushes pending writes before reading */
+func (self *luaStream) reading() error {
+	if self.r == nil {
+		return syscall.EBADF
+	}
+	return self.flush()
+}
+
+/* drops the read-ahead data of a file before writing */
+func (self *luaStream) writing() error {
+	if self.w == nil {
+		return syscall.EBADF
+	}
+	if self.r != nil && self.r.Buffered() > 0 {
+		if _, err := self.file.Seek(int64(-self.r.Buffered()), io.SeekCurrent); err != nil {
+			return err
+		}
+		self.r.Reset(self.file)
+	}
+	return nil
+}
+
+func (self *luaStream) write(s string) error {
+	if _, err := self.w.WriteString(s); err != nil {
+		return err
+	}
+	if self.vbuf == "no" || self.vbuf == "line" && strings.IndexByte(s, '\n') >= 0 {
+		return self.w.Flush()
+	}
+	return nil
+}
+
+func (self *luaStream) flush() error {
+	if self.w != nil {
+		return self.w.Flush()
+	}
+	return nil
+}
+
+/* whence is 0, 1 or 2 for "set", "cur" and "end" */
+func (self *luaStream) seek(offset int64, whence int) (int64, error) {
+	if self.file == nil {
+		return 0, syscall.ESPIPE
+	}
+	if err := self.flush(); err != nil {
+		return 0, err
+	}
+	if whence == io.SeekCurrent && self.r != nil {
+		offset -= int64(self.r.Buffered()) /* from the logical position */
+	}
+	pos, err := self.file.Seek(offset, whence)
+	if err == nil && self.r != nil {
+		self.r.Reset(self.file)
+	}
+	return pos, err
+}
+
+/* results */
+
+/* the message of err without the operation and file name of Go */
+func _errorString(err error) string {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return err.Error()
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_fileresult()
+func _fileResult(ls LuaState, err error, fname string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	ls.PushNil()
+	if fname != "" {
+		ls.PushString(fname + ": " + _errorString(err))
+	} else {
+		ls.PushString(_errorString(err))
+	}
+	var errno syscall.Errno
+	errors.As(err, &errno)
+	ls.PushInteger(int64(errno))
+	return 3
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_execresult()
+func _execResult(ls LuaState, err error) int {
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return _fileResult(ls, err, "")
+	}
+	what, stat := "exit", 0 /* type of termination and its status */
+	if exitErr != nil {
+		ws := exitErr.Sys().(syscall.WaitStatus)
+		if ws.Signaled() {
+			what, stat = "signal", int(ws.Signal())
+		} else {
+			stat = ws.ExitStatus()
+		}
+	}
+	if what == "exit" && stat == 0 { /* successful termination? */
+		ls.PushBoolean(true)
+	} else {
+		ls.PushNil()
+	}
+	ls.PushString(what)
+	ls.PushInteger(int64(stat))
+	return 3 /* return true/nil,what,code */
 }
@@ -183,19 +183,18 @@ func osExecute(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-os.exit
 // lua-5.3.4/src/loslib.c#os_exit()
 func osExit(ls LuaState) int {
+	code := 0
 	if ls.IsBoolean(1) {
-		if ls.ToBoolean(1) {
-			os.Exit(0)
-		} else {
-			os.Exit(1) // todo
+		if !ls.ToBoolean(1) {
+			code = 1 // todo
 		}
 	} else {
-		code := ls.OptInteger(1, 1)
-		os.Exit(int(code))
+		code = int(ls.OptInteger(1, 1))
 	}
 	if ls.ToBoolean(2) {
 		ls.Close()
 	}
+	os.Exit(code)
 	return 0
 }
 
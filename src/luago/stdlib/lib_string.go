@@ -1,6 +1,7 @@
 package stdlib
 
 import "fmt"
+import "math"
 import "strings"
 import . "luago/api"
 
@@ -65,11 +66,12 @@ func strRep(ls LuaState) int {
 	} else if n == 1 {
 		ls.PushString(s)
 	} else {
-		a := make([]string, n)
-		for i := 0; i < int(n); i++ {
-			a[i] = s
+		l := int64(len(s) + len(sep))
+		if l > math.MaxInt32/n { /* results are kept below 2^31 bytes, as in PUC Lua */
+			return ls.Error2("resulting string too large")
 		}
-		ls.PushString(strings.Join(a, sep))
+		ls.CheckStringLength(int(l*n) - len(sep))
+		ls.PushString(strings.Repeat(s+sep, int(n-1)) + s)
 	}
 
 	return 1
@@ -115,24 +117,29 @@ func strUpper(ls LuaState) int {
 // lua-5.3.4/src/lstrlib.c#str_sub()
 func strSub(ls LuaState) int {
 	s := ls.CheckString(1)
+	i := ls.CheckInteger(2)
+	j := ls.OptInteger(3, -1)
+	ls.PushString(subStr(s, i, j))
+	return 1
+}
+
+/* the substring from i to j, which may be negative or out of range */
+func subStr(s string, i, j int64) string {
 	sLen := len(s)
-	i := posRelat(ls.CheckInteger(2), sLen)
-	j := posRelat(ls.OptInteger(3, -1), sLen)
+	l := posRelat(i, sLen)
+	r := posRelat(j, sLen)
 
-	if i < 1 {
-		i = 1
+	if l < 1 {
+		l = 1
 	}
-	if j > sLen {
-		j = sLen
+	if r > sLen {
+		r = sLen
 	}
 
-	if i <= j {
-		ls.PushString(s[i-1 : j])
-	} else {
-		ls.PushString("")
+	if l <= r {
+		return s[l-1 : r]
 	}
-
-	return 1
+	return ""
 }
 
 // string.byte (s [, i [, j]])
@@ -278,15 +285,8 @@ func _fmtArg(tag string, ls LuaState, argIdx int) string {
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.find
 func strFind(ls LuaState) int {
 	s := ls.CheckString(1)
-	sLen := len(s)
 	pattern := ls.CheckString(2)
-	init := posRelat(ls.OptInteger(3, 1), sLen)
-	if init < 1 {
-		init = 1
-	} else if init > sLen+1 { /* start after string's end? */
-		ls.PushNil()
-		return 1
-	}
+	init := ls.OptInteger(3, 1)
 
 	plain := false
 	if ls.IsBoolean(4) {
@@ -337,15 +337,61 @@ func strMatch(ls LuaState) int {
 func strGsub(ls LuaState) int {
 	s := ls.CheckString(1)
 	pattern := ls.CheckString(2)
-	repl := ls.CheckString(3) // todo
+	tr := ls.Type(3)
 	n := int(ls.OptInteger(4, -1))
-
-	newStr, nMatches := gsub(s, pattern, repl, n)
+	ls.ArgCheck(tr == LUA_TNUMBER || tr == LUA_TSTRING ||
+		tr == LUA_TFUNCTION || tr == LUA_TTABLE, 3,
+		"string/function/table expected")
+
+	var newStr string
+	var nMatches int
+	if tr == LUA_TNUMBER || tr == LUA_TSTRING {
+		repl, _ := ls.ToString(3)
+		newStr, nMatches = gsub(s, pattern, repl, n)
+	} else {
+		newStr, nMatches = gsubFunc(s, pattern, n, func(match string, captures []interface{}) string {
+			return _addValue(ls, tr, match, captures)
+		})
+	}
 	ls.PushString(newStr)
 	ls.PushInteger(int64(nMatches))
 	return 2
 }
 
+/* computes the replacement of a match from a function or table */
+// lua-5.3.4/src/lstrlib.c#add_value()
+func _addValue(ls LuaState, tr LuaType, match string, captures []interface{}) string {
+	if tr == LUA_TFUNCTION {
+		ls.PushValue(3)
+		for _, capture := range captures {
+			_pushCapture(ls, capture)
+		}
+		ls.Call(len(captures), 1)
+	} else { /* LUA_TTABLE */
+		_pushCapture(ls, captures[0])
+		ls.GetTable(3)
+	}
+	if !ls.ToBoolean(-1) { /* nil or false? */
+		ls.Pop(1)
+		return match /* keep original text */
+	} else if !ls.IsString(-1) {
+		ls.Error2("invalid replacement value (a %s)", ls.TypeName2(-1))
+	}
+	repl, _ := ls.ToString(-1)
+	ls.Pop(1)
+	return repl
+}
+
+/* pushes a capture: a string, or the position of a position capture */
+// lua-5.3.4/src/lstrlib.c#push_onecapture()
+func _pushCapture(ls LuaState, capture interface{}) {
+	if pos, ok := capture.(int64); ok {
+		ls.PushInteger(pos)
+	} else {
+		ls.PushString(capture.(string))
+	}
+}
+
 // string.gmatch (s, pattern)
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.gmatch
 func strGmatch(ls LuaState) int {
@@ -0,0 +1,24 @@
+package stdlib_test
+
+import "testing"
+import "luago/state"
+
+func TestGsubReplacement(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+
+	err := ls.DoStringErr(`
+		local function check(s, expected)
+			assert(s == expected, s)
+		end
+		check(string.gsub("abc", "(a)b", function() end), "abc")
+		check(string.gsub("abc", "(a)b", {}), "abc")
+		check(string.gsub("abc", "(a)b", function() return false end), "abc")
+		check(string.gsub("abc", "(a)b", {a = "x"}), "xc")
+		check(string.gsub("abc", "()b", function(p) return p end), "a2c")
+		check(string.gsub("abc", "()b", {[2] = "y"}), "ayc")
+		check(string.gsub("abc", "(b)()", function(b, p) return b .. p end), "ab3c")`)
+	if err != nil {
+		t.Error(err)
+	}
+}
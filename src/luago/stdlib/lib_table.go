@@ -1,6 +1,6 @@
 package stdlib
 
-import "sort"
+import "math"
 import "strings"
 import . "luago/api"
 
@@ -123,15 +123,18 @@ func tabConcat(ls LuaState) int {
 	}
 
 	buf := make([]string, j-i+1)
+	size := len(sep) * int(j-i)
 	for k := i; k <= j; k++ {
 		ls.GetI(1, k)
 		if !ls.IsString(-1) {
 			ls.Error2("invalid value (%s) at index %d in table for 'concat'",
 				ls.TypeName2(-1), i)
 		}
-		buf[k-1], _ = ls.ToString(-1)
+		buf[k-i], _ = ls.ToString(-1)
+		size += len(buf[k-i])
 		ls.Pop(1)
 	}
+	ls.CheckStringLength(size)
 	ls.PushString(strings.Join(buf, sep))
 
 	return 1
@@ -192,58 +195,142 @@ func tabUnpack(ls LuaState) int {
 	if i > e { /* empty range */
 		return 0
 	}
-	// n = (lua_Unsigned)e - i;  /* number of elements minus 1 (avoid overflows) */
-	// if (n >= (unsigned int)INT_MAX  || !lua_checkstack(L, (int)(++n)))
-	//   return luaL_error(L, "too many results to unpack");
-	n := int(e - i + 1)
-	ls.CheckStack(n)
+	n := uint64(e) - uint64(i) /* number of elements minus 1 (avoid overflows) */
+	if n >= math.MaxInt32 || !ls.CheckStack(int(n+1)) {
+		return ls.Error2("too many results to unpack")
+	}
 	for ; i < e; i++ { /* push arg[i..e - 1] (to avoid overflows) */
 		ls.GetI(1, i)
 	}
 	ls.GetI(1, e) /* push last element */
-	return n
+	return int(n + 1)
 }
 
 /* sort */
 
 // table.sort (list [, comp])
 // http://www.lua.org/manual/5.3/manual.html#pdf-table.sort
+// lua-5.3.4/src/ltablib.c#sort()
 func tabSort(ls LuaState) int {
-	sort.Sort(wrapper{ls})
+	n := _auxGetN(ls, 1, TAB_RW)
+	if n > 1 { /* non-trivial interval? */
+		ls.ArgCheck(n < LUA_MAXINTEGER, 1, "array too big")
+		if !ls.IsNoneOrNil(2) { /* is there a 2nd argument? */
+			ls.CheckType(2, LUA_TFUNCTION) /* must be a function */
+		}
+		ls.SetTop(2) /* make sure there are two arguments */
+		_auxSort(ls, 1, n)
+	}
 	return 0
 }
 
-type wrapper struct {
-	ls LuaState
+// lua-5.3.4/src/ltablib.c#sort_comp()
+func _sortComp(ls LuaState, a, b int) bool {
+	if ls.IsNil(2) { /* no function? */
+		return ls.Compare(a, b, LUA_OPLT) /* a < b */
+	} else { /* function */
+		ls.PushValue(2)         /* push function */
+		ls.PushValue(a - 1)     /* -1 to compensate function */
+		ls.PushValue(b - 2)     /* -2 to compensate function and 'a' */
+		ls.Call(2, 1)           /* call function */
+		res := ls.ToBoolean(-1) /* get result */
+		ls.Pop(1)               /* pop result */
+		return res
+	}
 }
 
-func (self wrapper) Len() int {
-	return int(self.ls.Len2(1))
+// lua-5.3.4/src/ltablib.c#partition()
+func _partition(ls LuaState, lo, up int64) int64 {
+	i := lo     /* will be incremented before first use */
+	j := up - 1 /* will be decremented before first use */
+	/* loop invariant: a[lo .. i] <= P <= a[j .. up], a[up - 1] == P */
+	for {
+		/* next loop: repeat ++i while a[i] < P */
+		for {
+			i++
+			ls.GetI(1, i)
+			if !_sortComp(ls, -1, -2) {
+				break
+			}
+			if i == up-1 { /* a[i] < P  but a[up - 1] == P  ?? */
+				ls.Error2("invalid order function for sorting")
+			}
+			ls.Pop(1) /* remove a[i] */
+		}
+		/* after the loop, a[i] >= P and a[lo .. i - 1] < P */
+		/* next loop: repeat --j while P < a[j] */
+		for {
+			j--
+			ls.GetI(1, j)
+			if !_sortComp(ls, -3, -1) {
+				break
+			}
+			if j < i { /* j < i  but  a[j] > P ?? */
+				ls.Error2("invalid order function for sorting")
+			}
+			ls.Pop(1) /* remove a[j] */
+		}
+		/* after the loop, a[j] <= P and a[j + 1 .. up] >= P */
+		if j < i { /* no elements to be exchanged? */
+			ls.Pop(1) /* pop a[j] */
+			/* swap pivot (a[up - 1]) with a[i] to satisfy pred.: a[up - 1] == P */
+			_set2(ls, up-1, i)
+			return i
+		}
+		/* otherwise, swap a[i] - a[j] to restore invariant and repeat */
+		_set2(ls, i, j)
+	}
 }
 
-func (self wrapper) Less(i, j int) bool {
-	ls := self.ls
-	if ls.GetTop() == 2 { // cmp is given
-		ls.PushValue(2)
-		ls.GetI(1, int64(i+1))
-		ls.GetI(1, int64(j+1))
-		ls.Call(2, 1)
-		b := ls.ToBoolean(-1)
-		ls.Pop(1)
-		return b
-	} else { // cmp is missing
-		ls.GetI(1, int64(i+1))
-		ls.GetI(1, int64(j+1))
-		b := ls.Compare(-2, -1, LUA_OPLT)
-		ls.Pop(2)
-		return b
-	}
-}
-
-func (self wrapper) Swap(i, j int) {
-	ls := self.ls
-	ls.GetI(1, int64(i+1))
-	ls.GetI(1, int64(j+1))
-	ls.SetI(1, int64(i+1))
-	ls.SetI(1, int64(j+1))
+// lua-5.3.4/src/ltablib.c#auxsort()
+func _auxSort(ls LuaState, lo, up int64) {
+	for lo < up { /* loop for tail recursion */
+		/* sort elements 'lo', 'p', and 'up' */
+		ls.GetI(1, lo)
+		ls.GetI(1, up)
+		if _sortComp(ls, -1, -2) { /* a[up] < a[lo]? */
+			_set2(ls, lo, up) /* swap a[lo] - a[up] */
+		} else {
+			ls.Pop(2) /* remove both values */
+		}
+		if up-lo == 1 { /* only 2 elements? */
+			break /* already sorted */
+		}
+		p := (lo + up) / 2 /* middle element is a good pivot */
+		ls.GetI(1, p)
+		ls.GetI(1, lo)
+		if _sortComp(ls, -2, -1) { /* a[p] < a[lo]? */
+			_set2(ls, p, lo) /* swap a[p] - a[lo] */
+		} else {
+			ls.Pop(1) /* remove second element */
+			ls.GetI(1, up)
+			if _sortComp(ls, -1, -2) { /* a[up] < a[p]? */
+				_set2(ls, p, up) /* swap up - p */
+			} else {
+				ls.Pop(2) /* clean stack */
+			}
+		}
+		if up-lo == 2 { /* only 3 elements? */
+			break /* already sorted */
+		}
+		ls.GetI(1, p)      /* get median (Pivot) */
+		ls.PushValue(-1)   /* push Pivot */
+		ls.GetI(1, up-1)   /* push a[up - 1] */
+		_set2(ls, p, up-1) /* a[p] = a[up - 1]; a[up - 1] = a[p] */
+		p = _partition(ls, lo, up)
+		/* a[lo .. p - 1] <= a[p] == P <= a[p + 1 .. up] */
+		if p-lo < up-p { /* lower interval is shorter? */
+			_auxSort(ls, lo, p-1) /* call recursively for lower interval */
+			lo = p + 1            /* tail call for [p + 1 .. up] (upper interval) */
+		} else {
+			_auxSort(ls, p+1, up) /* call recursively for upper interval */
+			up = p - 1            /* tail call for [lo .. p - 1]  (lower interval) */
+		}
+	}
+}
+
+// lua-5.3.4/src/ltablib.c#set2()
+func _set2(ls LuaState, i, j int64) {
+	ls.SetI(1, i)
+	ls.SetI(1, j)
 }
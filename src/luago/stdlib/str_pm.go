@@ -28,10 +28,13 @@ var characterClasses = map[byte]string{
 	'X': "[[:^xdigit:]]", //
 }
 
-func find(s, pattern string, init int, plain bool) (start, end int) {
+/* positions of the first match from init, which may be negative; -1 if none */
+func find(s, pattern string, init int64, plain bool) (start, end int) {
 	tail := s
-	if init > 1 {
-		tail = s[init-1:]
+	if i := posRelat(init, len(s)); i > len(s)+1 { /* start after string's end? */
+		return -1, -1
+	} else if i > 1 {
+		tail = s[i-1:]
 	}
 
 	if plain {
@@ -99,6 +102,68 @@ func gsub(s, pattern, repl string, n int) (string, int) {
 	}
 }
 
+// like gsub, but each match is replaced by repl(match, captures), where
+// captures holds the whole match if the pattern has no captures; a
+// position capture is an int64 (its 1-based position), others are strings
+func gsubFunc(s, pattern string, n int,
+	repl func(match string, captures []interface{}) string) (string, int) {
+
+	re, err := _compile(pattern)
+	if err != "" {
+		panic(err) // todo
+	}
+
+	indexes := re.FindAllStringSubmatchIndex(s, n)
+	if indexes == nil {
+		return s, 0
+	}
+
+	isPos := _positionCaptures(pattern)
+	var buf bytes.Buffer
+	lastEnd := 0
+	for _, loc := range indexes {
+		match := s[loc[0]:loc[1]]
+		var captures []interface{}
+		if len(loc) > 2 {
+			for i := 2; i < len(loc); i += 2 {
+				if isPos[i/2-1] {
+					captures = append(captures, int64(loc[i]+1))
+				} else {
+					captures = append(captures, s[loc[i]:loc[i+1]])
+				}
+			}
+		} else {
+			captures = []interface{}{match}
+		}
+		buf.WriteString(s[lastEnd:loc[0]])
+		buf.WriteString(repl(match, captures))
+		lastEnd = loc[1]
+	}
+	buf.WriteString(s[lastEnd:])
+	return buf.String(), len(indexes)
+}
+
+// tells, for each capture of pattern, whether it is a position capture '()'
+func _positionCaptures(pattern string) []bool {
+	var isPos []bool
+	inBrackets := false
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '%':
+			i++ /* skip escaped character */
+		case '[':
+			inBrackets = true
+		case ']':
+			inBrackets = false
+		case '(':
+			if !inBrackets {
+				isPos = append(isPos, i+1 < len(pattern) && pattern[i+1] == ')')
+			}
+		}
+	}
+	return isPos
+}
+
 func _compile(pattern string) (*regexp.Regexp, string) {
 	expr, errStr := _toRegexp(pattern)
 	if errStr != "" {
@@ -13,14 +13,14 @@ func TestSubStr(t *testing.T) {
 }
 
 func TestFind(t *testing.T) {
-	assert.IntEqual(t, find("1234512345", "", 1, true), 1)
-	assert.IntEqual(t, find("1234512345", "234", 99, true), -1)
-	assert.IntEqual(t, find("1234512345", "234", 0, true), 2)
-	assert.IntEqual(t, find("1234512345", "234", 1, true), 2)
-	assert.IntEqual(t, find("1234512345", "234", 2, true), 2)
-	assert.IntEqual(t, find("1234512345", "234", 3, true), 7)
-	assert.IntEqual(t, find("1234512345", "234", -1, true), -1)
-	assert.IntEqual(t, find("1234512345", "234", -4, true), 7)
+	assert.IntEqual(t, findStart("1234512345", "", 1, true), 1)
+	assert.IntEqual(t, findStart("1234512345", "234", 99, true), -1)
+	assert.IntEqual(t, findStart("1234512345", "234", 0, true), 2)
+	assert.IntEqual(t, findStart("1234512345", "234", 1, true), 2)
+	assert.IntEqual(t, findStart("1234512345", "234", 2, true), 2)
+	assert.IntEqual(t, findStart("1234512345", "234", 3, true), 7)
+	assert.IntEqual(t, findStart("1234512345", "234", -1, true), -1)
+	assert.IntEqual(t, findStart("1234512345", "234", -4, true), 7)
 }
 
 func TestParseFmtStr(t *testing.T) {
@@ -31,3 +31,8 @@ func TestParseFmtStr(t *testing.T) {
 	assert.StringsEqual(t, parseFmtStr("%%%d"), []string{"%%", "%d"})
 	assert.StringsEqual(t, parseFmtStr("-%.20s.20s"), []string{"-", "%.20s", ".20s"})
 }
+
+func findStart(s, pattern string, init int64, plain bool) int {
+	start, _ := find(s, pattern, init, plain)
+	return start
+}
@@ -38,11 +38,10 @@ func tailCall(i Instruction, vm LuaVM) {
 	a, b, _ := i.ABC()
 	a += 1
 
-	// todo: optimize tail call!
-	c := 0
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	_popResults(a, c, vm)
+	if !vm.TailCall(nArgs) { /* Go function? */
+		_popResults(a, 0, vm) /* its results are returned by OP_RETURN */
+	}
 }
 
 // R(A), ... ,R(A+C-2) := R(A)(R(A+1), ... ,R(A+B-1))
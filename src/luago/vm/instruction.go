@@ -24,6 +24,38 @@ func (self Instruction) Execute(vm api.LuaVM) {
 	opcodes[self.Opcode()].action(self, vm)
 }
 
+// Completes an instruction whose call or metamethod was interrupted by
+// a yield, once the results of the call are on the top of the stack.
+// lua-5.3.4/src/lvm.c#luaV_finishOp()
+func (self Instruction) Finish(vm api.LuaVM) {
+	a, _, c := self.ABC()
+	switch self.Opcode() {
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR,
+		OP_MOD, OP_POW, OP_UNM, OP_BNOT, OP_LEN,
+		OP_GETTABUP, OP_GETTABLE, OP_SELF:
+		vm.Replace(a + 1)
+	case OP_EQ, OP_LT, OP_LE:
+		res := vm.ToBoolean(-1)
+		vm.Pop(3) // ~
+		if res != (a != 0) {
+			vm.AddPC(1)
+		}
+	case OP_CONCAT:
+		// remaining operands are below the result of the metamethod
+		vm.Concat(vm.GetTop() - vm.RegisterCount())
+		vm.Replace(a + 1)
+	case OP_TFORCALL:
+		_popResults(a+4, c+1, vm)
+	case OP_CALL:
+		_popResults(a+1, c, vm)
+	case OP_TAILCALL:
+		_popResults(a+1, 0, vm)
+	default:
+		/* OP_SETTABUP, OP_SETTABLE: nothing to do */
+	}
+}
+
 func (self Instruction) Opcode() int {
 	return int(self & 0x3F)
 }
@@ -65,3 +97,8 @@ func (self Instruction) BMode() byte {
 func (self Instruction) CMode() byte {
 	return opcodes[self.Opcode()].argCMode
 }
+
+// reports whether the instruction sets register A
+func (self Instruction) TestAMode() bool {
+	return opcodes[self.Opcode()].setAFlag != 0
+}